@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"errors"
 	"log/slog"
 
 	"github.com/spf13/cobra"
@@ -8,28 +9,55 @@ import (
 	"github.com/tderick/backup-companion-go/internal/config"
 )
 
+// runAllJobs is set by --all to run every job defined in the config.
+var runAllJobs bool
+
 // backupCmd represents the backup command
 var backupCmd = &cobra.Command{
-	Use:   "backup",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
-
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
-	Run: func(cmd *cobra.Command, args []string) {
+	Use:   "backup [job...]",
+	Short: "Run one or more backup jobs defined in the config file",
+	Long: `Run one or more backup jobs defined in the config file.
+
+Jobs are selected by name and run in the order given. Use --all to run every
+job in the config, sorted by name. For example:
+
+  backup-companion backup full_backup
+  backup-companion backup database_only files_only
+  backup-companion backup --all
+
+Exit codes: 0 when every job succeeded, 2 when the config is invalid,
+3 when some jobs, sources or destinations failed, and 4 when every job failed.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if runAllJobs && len(args) > 0 {
+			return errors.New("job names cannot be combined with --all")
+		}
+		if !runAllJobs && len(args) == 0 {
+			return errors.New("specify at least one job name or use --all")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
 		// Load config using the root-level --config (cfgPath)
 		cfg, err := config.LoadConfig(cfgPath)
 		if err != nil {
-
 			slog.Error("failed to load config", "error", err)
+			return withExitCode(exitConfigError, err)
+		}
+
+		jobNames, err := backup.SelectJobs(cfg, args, runAllJobs)
+		if err != nil {
+			return withExitCode(exitConfigError, err)
 		}
-		backup.Execute(cmd.Context(), cfg)
+
+		result := backup.Execute(cmd.Context(), cfg, jobNames)
+		return runResultError(result)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(backupCmd)
 
+	backupCmd.Flags().BoolVar(&runAllJobs, "all", false, "run every job defined in the config file")
 }
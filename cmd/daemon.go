@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"context"
+	"log/slog"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tderick/backup-companion-go/internal/config"
+	"github.com/tderick/backup-companion-go/internal/scheduler"
+)
+
+// shutdownTimeout bounds how long the daemon waits for running jobs on shutdown.
+var shutdownTimeout time.Duration
+
+// daemonCmd represents the daemon command
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Run scheduled backup jobs until stopped",
+	Long: `Run every job that has a schedule in the config file, until the process
+receives SIGINT or SIGTERM.
+
+A job never runs twice at the same time; if its previous run is still in
+progress when it is due, that run is skipped. On shutdown no new runs are
+started and running jobs are given --shutdown-timeout to finish their uploads.
+
+  jobs:
+    nightly_db:
+      schedule:
+        cron: "0 2 * * *"
+        timezone: "Europe/Paris"
+        jitter: "10m"`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		cfg, err := config.LoadConfig(cfgPath)
+		if err != nil {
+			slog.Error("failed to load config", "error", err)
+			return withExitCode(exitConfigError, err)
+		}
+
+		s, err := scheduler.New(cfg)
+		if err != nil {
+			return withExitCode(exitConfigError, err)
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		// Running jobs must not be cancelled by the shutdown signal itself, only
+		// once the shutdown timeout has expired.
+		jobCtx, cancelJobs := context.WithCancel(context.WithoutCancel(ctx))
+		defer cancelJobs()
+		go func() {
+			<-ctx.Done()
+			if shutdownTimeout > 0 {
+				time.AfterFunc(shutdownTimeout, cancelJobs)
+			}
+		}()
+
+		s.Run(ctx, jobCtx)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+
+	daemonCmd.Flags().DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Minute, "how long to wait for running jobs on shutdown before cancelling them (0 waits indefinitely)")
+}
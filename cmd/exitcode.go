@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+// Process exit codes, so that schedulers and alerting can tell failures apart.
+const (
+	exitOK             = 0
+	exitError          = 1 // usage errors and anything not covered below
+	exitConfigError    = 2 // the config file could not be loaded or is invalid
+	exitPartialFailure = 3 // some jobs, sources or destinations failed
+	exitTotalFailure   = 4 // every job failed
+)
+
+// exitCodeError carries the process exit code for an error returned by a command.
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e *exitCodeError) Error() string { return e.err.Error() }
+
+func (e *exitCodeError) Unwrap() error { return e.err }
+
+// withExitCode attaches an exit code to err.
+func withExitCode(code int, err error) error {
+	return &exitCodeError{code: code, err: err}
+}
+
+// exitCodeFor returns the exit code attached to err, or exitError.
+func exitCodeFor(err error) int {
+	var codeErr *exitCodeError
+	if errors.As(err, &codeErr) {
+		return codeErr.code
+	}
+	return exitError
+}
+
+// runResultError maps the outcome of a run to an error carrying its exit code,
+// or nil when every job succeeded.
+func runResultError(result models.RunResult) error {
+	switch result.Status() {
+	case models.StatusSuccess:
+		return nil
+	case models.StatusFailed:
+		return withExitCode(exitTotalFailure, errors.New("all backup jobs failed"))
+	default:
+		return withExitCode(exitPartialFailure, errors.New("one or more backup jobs did not complete successfully"))
+	}
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/spf13/cobra"
+	"github.com/tderick/backup-companion-go/internal/backup"
+	"github.com/tderick/backup-companion-go/internal/config"
+)
+
+var (
+	pruneAllJobs bool
+	pruneDryRun  bool
+)
+
+// pruneCmd represents the prune command
+var pruneCmd = &cobra.Command{
+	Use:   "prune [job...]",
+	Short: "Delete remote backups that fall outside the retention policy",
+	Long: `Delete the remote backups of one or more jobs that fall outside their
+retention policy. A policy is set per job or per destination; the destination's
+policy takes precedence. Jobs without a policy are never pruned.
+
+Only objects named <output name>-<timestamp>.tar, followed by the extension
+of the archive's compression, are considered. Use --dry-run to list what
+would be deleted without deleting anything.
+
+  backup-companion prune full_backup --dry-run
+  backup-companion prune --all`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if pruneAllJobs && len(args) > 0 {
+			return errors.New("job names cannot be combined with --all")
+		}
+		if !pruneAllJobs && len(args) == 0 {
+			return errors.New("specify at least one job name or use --all")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		cfg, err := config.LoadConfig(cfgPath)
+		if err != nil {
+			slog.Error("failed to load config", "error", err)
+			return withExitCode(exitConfigError, err)
+		}
+
+		jobNames, err := backup.SelectJobs(cfg, args, pruneAllJobs)
+		if err != nil {
+			return withExitCode(exitConfigError, err)
+		}
+
+		if err := backup.Prune(cmd.Context(), cfg, jobNames, pruneDryRun); err != nil {
+			return withExitCode(exitPartialFailure, err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+
+	pruneCmd.Flags().BoolVar(&pruneAllJobs, "all", false, "prune every job defined in the config file")
+	pruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "only log the backups that would be deleted")
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/spf13/cobra"
+	"github.com/tderick/backup-companion-go/internal/backup"
+	"github.com/tderick/backup-companion-go/internal/config"
+)
+
+var restoreOpts backup.RestoreOptions
+
+// restoreLatest is set by --latest; it is the default when no --snapshot is given.
+var restoreLatest bool
+
+// restoreCmd represents the restore command
+var restoreCmd = &cobra.Command{
+	Use:   "restore <job>",
+	Short: "Download and unpack a backup, optionally reloading a database",
+	Long: `Download a backup of a job from one of its destinations and unpack it into
+the target directory. By default the latest backup on the job's first
+destination is restored. Each directory source is unpacked below
+files/<source name> and each database dump below databases/<source name>.
+
+With --into-database, the dump of that database source found in the backup is
+also loaded back into it: .pgdump files with pg_restore and .sql or .sql.gz
+files with mysql. Existing PostgreSQL objects are dropped and recreated.
+
+  backup-companion restore full_backup --latest --target /tmp/restore
+  backup-companion restore database_only --snapshot database-backup-2024-01-20-15-30-22.tar.gz \
+    --target /tmp/restore --into-database production_db`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if err := cobra.ExactArgs(1)(cmd, args); err != nil {
+			return err
+		}
+		if restoreLatest && restoreOpts.Snapshot != "" {
+			return errors.New("--snapshot cannot be combined with --latest")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		cfg, err := config.LoadConfig(cfgPath)
+		if err != nil {
+			slog.Error("failed to load config", "error", err)
+			return withExitCode(exitConfigError, err)
+		}
+
+		jobNames, err := backup.SelectJobs(cfg, args, false)
+		if err != nil {
+			return withExitCode(exitConfigError, err)
+		}
+		restoreOpts.Job = jobNames[0]
+
+		if err := backup.Restore(cmd.Context(), cfg, restoreOpts); err != nil {
+			return withExitCode(exitTotalFailure, err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+
+	restoreCmd.Flags().StringVar(&restoreOpts.Snapshot, "snapshot", "", "object key of the backup to restore")
+	restoreCmd.Flags().BoolVar(&restoreLatest, "latest", false, "restore the most recent backup (default)")
+	restoreCmd.Flags().StringVar(&restoreOpts.TargetDir, "target", "", "directory to unpack the backup into")
+	restoreCmd.Flags().StringVar(&restoreOpts.Destination, "from", "", "destination to download from (default: the job's first destination)")
+	restoreCmd.Flags().StringVar(&restoreOpts.IntoDatabase, "into-database", "", "database source to reload from the dump in the backup")
+	restoreCmd.MarkFlagRequired("target")
+}
@@ -9,13 +9,15 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/tderick/backup-companion-go/internal/version"
 )
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
-	Use:   "backup-companion",
-	Short: "A brief description of your application",
-	Long:  `Backup Companion is a robust, production-ready Docker container that automates the backup of your databases (PostgreSQL, MySQL, MariaDB) and specified directories to any S3-compatible object storage provider.`,
+	Use:     "backup-companion",
+	Short:   "A brief description of your application",
+	Version: version.String(),
+	Long:    `Backup Companion is a robust, production-ready Docker container that automates the backup of your databases (PostgreSQL, MySQL, MariaDB) and specified directories to any S3-compatible object storage provider.`,
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error { // Use PersistentPreRunE
 		// Initialize logging before any command runs
 		return initLogger(cmd.Context())
@@ -32,7 +34,7 @@ var logLevel string
 func Execute() {
 	err := rootCmd.Execute()
 	if err != nil {
-		os.Exit(1)
+		os.Exit(exitCodeFor(err))
 	}
 }
 
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tderick/backup-companion-go/internal/backup"
+	"github.com/tderick/backup-companion-go/internal/config"
+)
+
+// snapshotsOutput is the output format selected by --output.
+var snapshotsOutput string
+
+// snapshotsCmd represents the snapshots command
+var snapshotsCmd = &cobra.Command{
+	Use:   "snapshots [job...]",
+	Short: "List the backups stored on each destination",
+	Long: `List the backups of one or more jobs (all jobs by default) stored on each of
+their destinations, with their timestamp and size. A backup that is missing
+from some of the job's destinations is flagged.
+
+  backup-companion snapshots
+  backup-companion snapshots full_backup --output json`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if snapshotsOutput != "table" && snapshotsOutput != "json" {
+			return fmt.Errorf("invalid output format %q, expected table or json", snapshotsOutput)
+		}
+		cmd.SilenceUsage = true
+
+		cfg, err := config.LoadConfig(cfgPath)
+		if err != nil {
+			slog.Error("failed to load config", "error", err)
+			return withExitCode(exitConfigError, err)
+		}
+
+		jobNames, err := backup.SelectJobs(cfg, args, len(args) == 0)
+		if err != nil {
+			return withExitCode(exitConfigError, err)
+		}
+
+		snapshots, listErr := backup.ListSnapshots(cmd.Context(), cfg, jobNames)
+
+		out := cmd.OutOrStdout()
+		if snapshotsOutput == "json" {
+			err = printSnapshotsJSON(out, snapshots)
+		} else {
+			err = printSnapshotsTable(out, snapshots)
+		}
+		if err != nil {
+			return err
+		}
+
+		if listErr != nil {
+			return withExitCode(exitPartialFailure, listErr)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(snapshotsCmd)
+
+	snapshotsCmd.Flags().StringVarP(&snapshotsOutput, "output", "o", "table", "output format (table, json)")
+}
+
+// printSnapshotsTable prints one row per copy of each snapshot.
+func printSnapshotsTable(out io.Writer, snapshots []backup.Snapshot) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB\tTIMESTAMP\tSIZE\tDESTINATION\tKEY\tMISSING FROM")
+	for _, snapshot := range snapshots {
+		missing := "-"
+		if len(snapshot.Missing) > 0 {
+			missing = strings.Join(snapshot.Missing, ",")
+		}
+		for _, c := range snapshot.Copies {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				snapshot.Job,
+				snapshot.Time.Format(time.DateTime),
+				formatSize(c.Size),
+				c.Destination,
+				c.Key,
+				missing,
+			)
+		}
+	}
+	return w.Flush()
+}
+
+func printSnapshotsJSON(out io.Writer, snapshots []backup.Snapshot) error {
+	if snapshots == nil {
+		snapshots = []backup.Snapshot{}
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(snapshots)
+}
+
+// formatSize formats a byte count using binary units.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/tderick/backup-companion-go/internal/backup"
+	"github.com/tderick/backup-companion-go/internal/config"
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+var verifyOpts backup.VerifyOptions
+
+var (
+	// verifyLatest is set by --latest; it is the default when neither
+	// --snapshot nor --all is given.
+	verifyLatest bool
+	// verifyOutput is the output format selected by --output.
+	verifyOutput string
+)
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify <job>",
+	Short: "Check that remote backups are intact",
+	Long: `Download backups of a job from each of its destinations and check them
+end-to-end. Each archive is streamed, decrypted and decompressed, and every
+file in it is compared against the checksums of the archive's manifest.
+Archives made before manifests were added only have their framing checked.
+PostgreSQL dumps must also pass pg_restore --list.
+
+By default the latest backup on every destination of the job is verified.
+
+  backup-companion verify full_backup
+  backup-companion verify full_backup --all --from s3_primary
+  backup-companion verify database_only --snapshot database-backup-2024-01-20-15-30-22.tar.gz
+
+Exit codes: 0 when every backup is intact, 2 when the config is invalid,
+3 when some backups failed verification, and 4 when all of them did.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if err := cobra.ExactArgs(1)(cmd, args); err != nil {
+			return err
+		}
+		selected := 0
+		for _, set := range []bool{verifyOpts.Snapshot != "", verifyLatest, verifyOpts.All} {
+			if set {
+				selected++
+			}
+		}
+		if selected > 1 {
+			return errors.New("only one of --snapshot, --latest and --all can be given")
+		}
+		if verifyOutput != "table" && verifyOutput != "json" {
+			return fmt.Errorf("invalid output format %q, expected table or json", verifyOutput)
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		cfg, err := config.LoadConfig(cfgPath)
+		if err != nil {
+			slog.Error("failed to load config", "error", err)
+			return withExitCode(exitConfigError, err)
+		}
+
+		jobNames, err := backup.SelectJobs(cfg, args, false)
+		if err != nil {
+			return withExitCode(exitConfigError, err)
+		}
+		verifyOpts.Job = jobNames[0]
+
+		results, err := backup.Verify(cmd.Context(), cfg, verifyOpts)
+		if err != nil {
+			return withExitCode(exitConfigError, err)
+		}
+
+		out := cmd.OutOrStdout()
+		if verifyOutput == "json" {
+			err = printVerifyJSON(out, results)
+		} else {
+			err = printVerifyTable(out, results)
+		}
+		if err != nil {
+			return err
+		}
+		return verifyResultError(results)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+
+	verifyCmd.Flags().StringVar(&verifyOpts.Snapshot, "snapshot", "", "name or object key of the backup to verify")
+	verifyCmd.Flags().BoolVar(&verifyLatest, "latest", false, "verify the most recent backup (default)")
+	verifyCmd.Flags().BoolVar(&verifyOpts.All, "all", false, "verify every backup of the job")
+	verifyCmd.Flags().StringVar(&verifyOpts.Destination, "from", "", "only verify the backups on this destination")
+	verifyCmd.Flags().StringVarP(&verifyOutput, "output", "o", "table", "output format (table, json)")
+}
+
+// printVerifyTable prints one row per verified archive.
+func printVerifyTable(out io.Writer, results []backup.VerifyResult) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB\tDESTINATION\tKEY\tFILES\tMANIFEST\tSTATUS\tERROR")
+	for _, r := range results {
+		key, errMsg := r.Key, r.Error
+		if key == "" {
+			key = "-"
+		}
+		if errMsg == "" {
+			errMsg = "-"
+		}
+		manifest := "no"
+		if r.Manifest {
+			manifest = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", r.Job, r.Destination, key, r.Files, manifest, r.Status, errMsg)
+	}
+	return w.Flush()
+}
+
+func printVerifyJSON(out io.Writer, results []backup.VerifyResult) error {
+	if results == nil {
+		results = []backup.VerifyResult{}
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(results)
+}
+
+// verifyResultError maps the verification results to an error carrying the
+// exit code, or nil when every backup is intact.
+func verifyResultError(results []backup.VerifyResult) error {
+	failed := 0
+	for _, r := range results {
+		if r.Status != models.StatusSuccess {
+			failed++
+		}
+	}
+	switch {
+	case failed == 0:
+		return nil
+	case failed == len(results):
+		return withExitCode(exitTotalFailure, errors.New("every backup failed verification"))
+	default:
+		return withExitCode(exitPartialFailure, errors.New("some backups failed verification"))
+	}
+}
@@ -2,34 +2,98 @@ package backup
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/tderick/backup-companion-go/internal/backup/database"
 	"github.com/tderick/backup-companion-go/internal/backup/filesystem"
+	"github.com/tderick/backup-companion-go/internal/backup/manifest"
+	"github.com/tderick/backup-companion-go/internal/backup/objectkey"
 	"github.com/tderick/backup-companion-go/internal/backup/remotestorage"
 	"github.com/tderick/backup-companion-go/internal/backup/util"
 	"github.com/tderick/backup-companion-go/internal/models"
 )
 
-func Execute(ctx context.Context, cfg *models.Config) {
-	for jobName, job := range cfg.Jobs {
-		backupJob(ctx, cfg, jobName, job)
+// Execute runs the named jobs one after another, in the order given, and
+// reports the outcome of each one. Job names are expected to have been
+// checked with SelectJobs.
+func Execute(ctx context.Context, cfg *models.Config, jobNames []string) models.RunResult {
+	var result models.RunResult
+	for _, jobName := range jobNames {
+		jobResult := backupJob(ctx, cfg, jobName, cfg.Jobs[jobName])
+		slog.Info("Backup job finished",
+			"job_name", jobName,
+			"status", jobResult.Status,
+			"duration", jobResult.Duration,
+			"archive_size", jobResult.ArchiveSize,
+		)
+		result.Jobs = append(result.Jobs, jobResult)
 	}
+	return result
 }
 
-func backupJob(ctx context.Context, cfg *models.Config, jobName string, job models.JobConfig) {
+// SelectJobs resolves the jobs to run. With all set, every job in the config
+// is returned sorted by name; otherwise the given names are checked against
+// the config and returned in order, without duplicates.
+func SelectJobs(cfg *models.Config, names []string, all bool) ([]string, error) {
+	if all {
+		jobNames := make([]string, 0, len(cfg.Jobs))
+		for jobName := range cfg.Jobs {
+			jobNames = append(jobNames, jobName)
+		}
+		sort.Strings(jobNames)
+		return jobNames, nil
+	}
+
+	var unknown []string
+	seen := make(map[string]bool, len(names))
+	jobNames := make([]string, 0, len(names))
+	for _, name := range names {
+		if _, ok := cfg.Jobs[name]; !ok {
+			unknown = append(unknown, fmt.Sprintf("%q", name))
+			continue
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		jobNames = append(jobNames, name)
+	}
+
+	if len(unknown) > 0 {
+		available := make([]string, 0, len(cfg.Jobs))
+		for jobName := range cfg.Jobs {
+			available = append(available, jobName)
+		}
+		sort.Strings(available)
+		return nil, fmt.Errorf("unknown job(s) %s; available jobs: %s", strings.Join(unknown, ", "), strings.Join(available, ", "))
+	}
+	return jobNames, nil
+}
+
+func backupJob(ctx context.Context, cfg *models.Config, jobName string, job models.JobConfig) (result models.JobResult) {
 	slog.Info("Starting backup job", "jobName", jobName, "job", job)
 
+	result = models.JobResult{Job: jobName, StartedAt: time.Now()}
+	defer func() {
+		result.Resolve()
+		result.Duration = time.Since(result.StartedAt)
+	}()
+
 	// Validate database sources for this job
 	if err := validateJobDatabases(ctx, cfg, jobName, job); err != nil {
 		slog.Error("Skipping backup job due to database source validation failures",
 			"job_name", jobName,
 			"error", err,
 		)
-		return
+		result.Fail(err)
+		return result
 	}
 	slog.Info("All database sources for job validated successfully", "job_name", jobName)
 
@@ -39,18 +103,28 @@ func backupJob(ctx context.Context, cfg *models.Config, jobName string, job mode
 			"job_name", jobName,
 			"error", err,
 		)
-		return
+		result.Fail(err)
+		return result
 	}
 	slog.Info("All remote destinations for job validated successfully", "job_name", jobName)
 
+	if job.Output.Streaming {
+		streamJob(ctx, cfg, jobName, job, &result)
+		return result
+	}
+
+	// Finish uploads that an earlier, interrupted run of this job left behind
+	remotestorage.ResumeInterruptedUploads(ctx, cfg, job)
+
 	// Create a temporary directory for this job's backup artifacts
 	backupDir, err := util.CreateBackupDir(job.Output)
 	if err != nil {
 		slog.Error("Failed to create a backup directory", "jobName", jobName, "error", err)
-		return
+		result.Fail(err)
+		return result
 	}
 
-	archivePath := backupDir + ".tar.gz"
+	archivePath := backupDir + util.ArchiveExtension(job.Output.Compression)
 
 	defer func() {
 		if err := os.RemoveAll(backupDir); err != nil {
@@ -58,7 +132,9 @@ func backupJob(ctx context.Context, cfg *models.Config, jobName string, job mode
 		} else {
 			slog.Info("Cleaned up temporary backup directory", "backupDir", backupDir, "jobName", jobName)
 		}
-		if err := os.Remove(archivePath); err != nil {
+		if remotestorage.HasPendingUploads(archivePath) {
+			slog.Warn("Keeping archive file so that its interrupted upload can be resumed by the next run", "archivePath", archivePath, "jobName", jobName)
+		} else if err := os.Remove(archivePath); err != nil && !os.IsNotExist(err) {
 			slog.Error("Failed to cleanup archive file", "archivePath", archivePath, "jobName", jobName, "error", err)
 		} else {
 			slog.Info("Cleaned up archive file", "archivePath", archivePath, "jobName", jobName)
@@ -66,36 +142,98 @@ func backupJob(ctx context.Context, cfg *models.Config, jobName string, job mode
 	}()
 
 	// Determine job type and call appropriate handlers
+	originals := make(util.Originals)
 	switch getJobType(job) {
 	case "files-only":
-		filesystem.BackupFilesOnly(ctx, cfg, job, backupDir)
+		result.Sources = filesystem.BackupFilesOnly(ctx, cfg, job, backupDir, originals)
 	case "databases-only":
-		database.BackupDatabasesOnly(ctx, cfg, job, backupDir)
+		result.Sources = database.BackupDatabasesOnly(ctx, cfg, job, backupDir)
 	case "both":
-		filesystem.BackupFilesOnly(ctx, cfg, job, backupDir)
-		database.BackupDatabasesOnly(ctx, cfg, job, backupDir)
+		result.Sources = filesystem.BackupFilesOnly(ctx, cfg, job, backupDir, originals)
+		result.Sources = append(result.Sources, database.BackupDatabasesOnly(ctx, cfg, job, backupDir)...)
 	}
 
-	if err := util.CreateTarGz(backupDir, archivePath); err != nil {
+	if !anySourceSucceeded(result.Sources) {
+		err := errors.New("every source of the job failed, nothing to archive")
+		slog.Error("Skipping archive and upload", "jobName", jobName, "error", err)
+		result.Fail(err)
+		return result
+	}
+
+	m := manifest.New(jobName, result.StartedAt)
+	m.Finish(result.Sources)
+	sum := remotestorage.NewChecksummer()
+	if err := util.CreateArchive(backupDir, archivePath, job.Output.Compression, m, originals, sum); err != nil {
 		slog.Error("Failed to create archive", "jobName", jobName, "error", err)
-		return
+		result.Fail(err)
+		return result
 	}
-	slog.Info("Successfully created archive", "jobName", jobName, "archivePath", archivePath)
+	digest := sum.Digest()
+	result.ArchiveSize = digest.Size
+	slog.Info("Successfully created archive", "jobName", jobName, "archivePath", archivePath, "size", result.ArchiveSize)
 
-	// Call the new function to upload the archive to destinations
-	if err := remotestorage.UploadArchiveToDestinations(ctx, cfg, job, archivePath); err != nil {
-		slog.Error("Failed to upload archive to one or more destinations",
+	archiveKey := archiveKeys(cfg, jobName, job, filepath.Base(archivePath))
+	result.Destinations = remotestorage.UploadArchiveToDestinations(ctx, cfg, job, archivePath, digest, archiveKey)
+	remotestorage.UploadManifest(ctx, cfg, job, result.Destinations, m)
+	if anyDestinationSucceeded(result.Destinations) {
+		slog.Info("Archive uploaded for job",
 			"job_name", jobName,
 			"archive_path", archivePath,
-			"error", err,
 		)
 	} else {
-		slog.Info("Archive successfully uploaded to all destinations for job",
+		slog.Error("Failed to upload archive to every destination",
 			"job_name", jobName,
 			"archive_path", archivePath,
 		)
 	}
 
+	return result
+}
+
+// archiveKeys locates the named archive on each destination of the job: its
+// key is the archive name under the prefix the job's layout on that
+// destination gives it. The prefix is rendered with the time in the archive
+// name, so that listing the destination finds the archive again.
+func archiveKeys(cfg *models.Config, jobName string, job models.JobConfig, archiveName string) remotestorage.KeyFunc {
+	return func(destName string) (remotestorage.ArchiveKey, error) {
+		t, ok := util.ParseArchiveName(job.Output.Name, archiveName)
+		if !ok {
+			return remotestorage.ArchiveKey{}, fmt.Errorf("unexpected archive name %q", archiveName)
+		}
+		layout, err := objectkey.New(jobName, job, cfg.Destinations[destName])
+		if err != nil {
+			return remotestorage.ArchiveKey{}, err
+		}
+		key, err := layout.Key(archiveName, t)
+		if err != nil {
+			return remotestorage.ArchiveKey{}, err
+		}
+		jobPrefix, err := layout.ListPrefix()
+		if err != nil {
+			return remotestorage.ArchiveKey{}, err
+		}
+		return remotestorage.ArchiveKey{Key: key, JobPrefix: jobPrefix}, nil
+	}
+}
+
+// anySourceSucceeded reports whether at least one source was backed up.
+func anySourceSucceeded(sources []models.SourceResult) bool {
+	for _, source := range sources {
+		if source.Status == models.StatusSuccess {
+			return true
+		}
+	}
+	return false
+}
+
+// anyDestinationSucceeded reports whether at least one destination received the archive.
+func anyDestinationSucceeded(destinations []models.DestinationResult) bool {
+	for _, destination := range destinations {
+		if destination.Status == models.StatusSuccess {
+			return true
+		}
+	}
+	return false
 }
 
 func getJobType(job models.JobConfig) string {
@@ -128,24 +266,24 @@ func validateJobDatabases(ctx context.Context, cfg *models.Config, jobName strin
 	}
 
 	if len(validationErrors) > 0 {
-		return fmt.Errorf(strings.Join(validationErrors, "; "))
+		return errors.New(strings.Join(validationErrors, "; "))
 	}
 	return nil
 }
 
-// validateJobDestinations validates all remote S3 destinations referenced by a job.
+// validateJobDestinations validates all destinations referenced by a job.
 func validateJobDestinations(ctx context.Context, cfg *models.Config, jobName string, job models.JobConfig) error {
 	var validationErrors []string
 	for _, destName := range job.Destinations {
 		if destConfig, ok := cfg.Destinations[destName]; ok {
-			slog.Debug("Attempting to create S3 client for destination", "destination", destName)
-			s3Client, err := remotestorage.NewS3Client(ctx, destConfig)
+			slog.Debug("Attempting to create client for destination", "destination", destName, "provider", destConfig.Provider)
+			dest, err := remotestorage.New(ctx, destConfig)
 			if err != nil {
-				validationErrors = append(validationErrors, fmt.Sprintf("failed to create S3 client for destination %q: %v", destName, err))
+				validationErrors = append(validationErrors, fmt.Sprintf("failed to create client for destination %q: %v", destName, err))
 				continue
 			}
 			slog.Debug("Attempting to validate connection for destination", "destination", destName)
-			if err := s3Client.ValidateConnection(ctx); err != nil {
+			if err := dest.Validate(ctx); err != nil {
 				validationErrors = append(validationErrors, fmt.Sprintf("destination %q failed connection validation: %v", destName, err))
 			} else {
 				slog.Info("Destination validated successfully", "destination", destName)
@@ -156,7 +294,7 @@ func validateJobDestinations(ctx context.Context, cfg *models.Config, jobName st
 	}
 
 	if len(validationErrors) > 0 {
-		return fmt.Errorf(strings.Join(validationErrors, "; "))
+		return errors.New(strings.Join(validationErrors, "; "))
 	}
 	return nil
 }
@@ -0,0 +1,156 @@
+// Package compression compresses archives with the algorithm chosen for
+// their job, and recognises the algorithm of an existing archive from its
+// magic bytes so that archives can be read whatever they were written with.
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"runtime"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/tderick/backup-companion-go/internal/models"
+	"github.com/ulikunitz/xz"
+)
+
+// Supported algorithms.
+const (
+	Gzip = "gzip"
+	Zstd = "zstd"
+	Xz   = "xz"
+	None = "none"
+)
+
+// Magic bytes at the start of a stream compressed with each algorithm.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// xzDictCaps are the dictionary sizes of the xz presets 0 to 9.
+var xzDictCaps = []int{256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+
+// Algorithm returns the algorithm cfg selects, gzip when it selects none.
+func Algorithm(cfg models.CompressionConfig) string {
+	if cfg.Algorithm == "" {
+		return Gzip
+	}
+	return cfg.Algorithm
+}
+
+// Enabled reports whether cfg compresses archives at all.
+func Enabled(cfg models.CompressionConfig) bool {
+	return Algorithm(cfg) != None
+}
+
+// Extension returns the extension of files compressed with the algorithm,
+// e.g. ".zst", or "" for none.
+func Extension(algorithm string) string {
+	switch algorithm {
+	case Gzip:
+		return ".gz"
+	case Zstd:
+		return ".zst"
+	case Xz:
+		return ".xz"
+	default:
+		return ""
+	}
+}
+
+// Extensions lists the extension of every algorithm, longest first.
+func Extensions() []string {
+	return []string{".zst", ".gz", ".xz", ""}
+}
+
+// Validate checks that the algorithm is supported and the level is within
+// its range. A level of 0 selects the algorithm's default.
+func Validate(cfg models.CompressionConfig) error {
+	var maxLevel int
+	switch Algorithm(cfg) {
+	case Gzip:
+		maxLevel = gzip.BestCompression
+	case Zstd:
+		maxLevel = 22
+	case Xz:
+		maxLevel = len(xzDictCaps) - 1
+	case None:
+		maxLevel = 0
+	default:
+		return fmt.Errorf("unsupported algorithm %q, expected gzip, zstd, xz or none", cfg.Algorithm)
+	}
+	if cfg.Level < 0 || cfg.Level > maxLevel {
+		return fmt.Errorf("level %d is out of range for %s (0-%d)", cfg.Level, Algorithm(cfg), maxLevel)
+	}
+	return nil
+}
+
+// NewWriter returns a writer that compresses everything written to it into
+// w. It must be closed to flush the end of the stream. zstd compresses
+// blocks on all available CPUs.
+func NewWriter(w io.Writer, cfg models.CompressionConfig) (io.WriteCloser, error) {
+	switch Algorithm(cfg) {
+	case Gzip:
+		level := cfg.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case Zstd:
+		options := []zstd.EOption{zstd.WithEncoderConcurrency(runtime.GOMAXPROCS(0))}
+		if cfg.Level != 0 {
+			options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(cfg.Level)))
+		}
+		return zstd.NewWriter(w, options...)
+	case Xz:
+		xzConfig := xz.WriterConfig{}
+		if cfg.Level != 0 {
+			xzConfig.DictCap = xzDictCaps[cfg.Level]
+		}
+		return xzConfig.NewWriter(w)
+	case None:
+		return nopWriteCloser{w}, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression algorithm %q", cfg.Algorithm)
+	}
+}
+
+// NewReader returns a reader that decompresses r, whichever algorithm it was
+// compressed with. Streams without a known magic number are read as is.
+func NewReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(xzMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		decoder, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case bytes.HasPrefix(magic, xzMagic):
+		xzReader, err := xz.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xzReader), nil
+	default:
+		return io.NopCloser(br), nil
+	}
+}
+
+// nopWriteCloser is a writer whose Close does nothing.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -9,35 +9,65 @@ import (
 	"log/slog"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
+	"github.com/tderick/backup-companion-go/internal/backup/compression"
+	"github.com/tderick/backup-companion-go/internal/backup/util"
 	"github.com/tderick/backup-companion-go/internal/models"
 )
 
-func BackupDatabasesOnly(ctx context.Context, cfg *models.Config, job models.JobConfig, backupDir string) {
+// BackupDatabasesOnly dumps every database referenced by the job into backupDir,
+// each below databases/<source name>, and reports the outcome of each one. The dumps are only compressed when the
+// job's archive is not, so that their data is never compressed twice.
+func BackupDatabasesOnly(ctx context.Context, cfg *models.Config, job models.JobConfig, backupDir string) []models.SourceResult {
+	compress := !compression.Enabled(job.Output.Compression)
+	results := make([]models.SourceResult, 0, len(job.Databases))
 	for _, dbName := range job.Databases {
+		start := time.Now()
+		result := models.SourceResult{Name: dbName, Kind: "database", Status: models.StatusSuccess}
+		result.ArchivePath = path.Join(util.DatabasesDir, dbName)
+
+		var err error
 		if dbConfig, ok := cfg.Sources.Databases[dbName]; ok {
-			BackupDatabase(ctx, dbConfig, backupDir)
+			err = BackupDatabase(ctx, dbConfig, filepath.Join(backupDir, filepath.FromSlash(result.ArchivePath)), compress)
 		} else {
+			err = fmt.Errorf("database %q not found in sources", dbName)
 			slog.Error("Database referenced by job not found in sources", "database_name", dbName, "job_name", job.Output.Name)
 		}
+		if err != nil {
+			result.Status = models.StatusFailed
+			result.Error = err.Error()
+		}
+
+		result.Duration = time.Since(start)
+		results = append(results, result)
 	}
+	return results
 }
 
 // BackupDatabase dispatches the backup operation to the appropriate driver-specific function.
-func BackupDatabase(ctx context.Context, db models.DatabaseConfig, backupDir string) {
-	slog.Info("Performing backup for database", "db_name", db.Name, "driver", db.Driver, "backup_dir", backupDir)
+// compress selects whether the dump compresses its own data, which is
+// wasted effort when it is added to a compressed archive.
+func BackupDatabase(ctx context.Context, db models.DatabaseConfig, backupDir string, compress bool) error {
+	slog.Info("Performing backup for database", "db_name", db.Name, "driver", db.Driver, "backup_dir", backupDir, "compress", compress)
+
+	if err := os.MkdirAll(backupDir, 0755); err != nil {
+		return fmt.Errorf("error creating directory for database dump: %w", err)
+	}
 
 	// Determine file extension based on driver
 	var fileExtension string
-	switch db.Driver {
-	case "postgres":
-		fileExtension = ".pgdump" // Custom binary format
-	case "mysql":
+	switch {
+	case db.Driver == "postgres":
+		fileExtension = ".pgdump" // Custom binary format, compressed or not
+	case db.Driver == "mysql" && compress:
 		fileExtension = ".sql.gz" // mysqldump produces SQL, then gzip compresses it
+	case db.Driver == "mysql":
+		fileExtension = ".sql"
 	default:
 		// This case should ideally be caught by validation, but as a fallback
 		fileExtension = ".unknown.dump"
@@ -48,32 +78,36 @@ func BackupDatabase(ctx context.Context, db models.DatabaseConfig, backupDir str
 	var err error
 	switch db.Driver {
 	case "postgres":
-		err = backupPostgres(ctx, db, outputPath)
+		err = backupPostgres(ctx, db, outputPath, compress)
 	case "mysql":
-		err = backupMysql(ctx, db, outputPath)
+		err = backupMysql(ctx, db, outputPath, compress)
 	default:
 		err = fmt.Errorf("unsupported database driver for backup: %q", db.Driver)
 	}
 
 	if err != nil {
 		slog.Error("Database backup failed", "db_name", db.Name, "driver", db.Driver, "error", err)
-	} else {
-		slog.Info("Database backup completed successfully", "db_name", db.Name, "driver", db.Driver, "path", outputPath)
+		return err
 	}
+	slog.Info("Database backup completed successfully", "db_name", db.Name, "driver", db.Driver, "path", outputPath)
+	return nil
 }
 
 // backupPostgres performs a backup of a PostgreSQL database using pg_dump.
-func backupPostgres(ctx context.Context, db models.DatabaseConfig, outputPath string) error {
+func backupPostgres(ctx context.Context, db models.DatabaseConfig, outputPath string, compress bool) error {
 	args := []string{
 		"-h", db.Host,
 		"-p", fmt.Sprintf("%d", db.Port),
 		"-U", db.User,
-		"-F", "c", // Custom format (compressed)
+		"-F", "c", // Custom format (compressed unless -Z 0)
 		"-b", // Include large objects
 		"-v", // Verbose mode
 		"-f", outputPath,
-		db.Name,
 	}
+	if !compress {
+		args = append(args, "-Z", "0")
+	}
+	args = append(args, db.Name)
 
 	cmd := exec.CommandContext(ctx, "pg_dump", args...)
 	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", db.Password)) // Pass password securely via env
@@ -84,8 +118,9 @@ func backupPostgres(ctx context.Context, db models.DatabaseConfig, outputPath st
 	return nil
 }
 
-// backupMysql performs a backup of a MySQL database using mysqldump and pipes to gzip.
-func backupMysql(ctx context.Context, db models.DatabaseConfig, outputPath string) error {
+// backupMysql performs a backup of a MySQL database using mysqldump, piping
+// it through gzip when compress is set.
+func backupMysql(ctx context.Context, db models.DatabaseConfig, outputPath string, compress bool) error {
 	args := []string{
 		"-h", db.Host,
 		fmt.Sprintf("-P%d", db.Port),
@@ -106,12 +141,14 @@ func backupMysql(ctx context.Context, db models.DatabaseConfig, outputPath strin
 	}
 	defer outFile.Close() // Ensure file is closed
 
-	// Create a gzip writer to compress the mysqldump output
-	gzipWriter := gzip.NewWriter(outFile)
-	defer gzipWriter.Close() // Ensure gzip writer is closed and flushed
-
-	// Pipe mysqldump's stdout directly to the gzip writer
-	cmd.Stdout = gzipWriter
+	// Pipe mysqldump's stdout directly to the file, or to a gzip writer
+	// compressing into it
+	cmd.Stdout = outFile
+	if compress {
+		gzipWriter := gzip.NewWriter(outFile)
+		defer gzipWriter.Close() // Ensure gzip writer is closed and flushed
+		cmd.Stdout = gzipWriter
+	}
 
 	// Capture stderr for logging potential mysqldump errors
 	var stderr bytes.Buffer
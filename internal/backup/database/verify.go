@@ -0,0 +1,27 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// CheckPostgresDump checks that a custom-format dump read from r can be read
+// by pg_restore, by listing its table of contents. pg_restore may stop
+// reading once it has the table of contents, so the caller must drain r if
+// it needs the rest of the dump.
+func CheckPostgresDump(ctx context.Context, r io.Reader) error {
+	cmd := exec.CommandContext(ctx, "pg_restore", "--list")
+	cmd.Stdin = r
+	cmd.Stdout = io.Discard
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("pg_restore --list failed: %w\nStderr: %s", err, stderr.String())
+	}
+	return nil
+}
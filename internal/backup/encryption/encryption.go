@@ -0,0 +1,133 @@
+package encryption
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+// Extension is appended to the object key of encrypted archives.
+const Extension = ".age"
+
+// MetadataKey is the object metadata key recording the encryption scheme.
+const MetadataKey = "encryption"
+
+// Encryption schemes recorded in object metadata.
+const (
+	SchemeX25519     = "age-x25519"
+	SchemePassphrase = "age-scrypt"
+)
+
+// Effective returns the encryption settings that apply to a job on a
+// destination: the destination's own settings if it has any, otherwise the
+// job's. It returns nil when archives are uploaded unencrypted.
+func Effective(job models.JobConfig, dest models.DestinationConfig) *models.EncryptionConfig {
+	if dest.Encryption != nil {
+		return dest.Encryption
+	}
+	return job.Encryption
+}
+
+// Scheme returns the name of the scheme used by the settings.
+func Scheme(enc models.EncryptionConfig) string {
+	if enc.Passphrase != "" {
+		return SchemePassphrase
+	}
+	return SchemeX25519
+}
+
+// Validate checks that exactly one of recipients or passphrase is set and
+// that every recipient is a valid age X25519 public key.
+func Validate(enc models.EncryptionConfig) error {
+	switch {
+	case len(enc.Recipients) > 0 && enc.Passphrase != "":
+		return errors.New("set either recipients or a passphrase, not both")
+	case len(enc.Recipients) == 0 && enc.Passphrase == "":
+		return errors.New("requires recipients or a passphrase")
+	}
+	_, err := recipients(enc)
+	return err
+}
+
+// Encrypt returns a writer that encrypts everything written to it into dst.
+// The returned writer must be closed to flush the last chunk.
+func Encrypt(dst io.Writer, enc models.EncryptionConfig) (io.WriteCloser, error) {
+	rs, err := recipients(enc)
+	if err != nil {
+		return nil, err
+	}
+	w, err := age.Encrypt(dst, rs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start encryption: %w", err)
+	}
+	return w, nil
+}
+
+// Decrypt returns a reader that decrypts src. Archives encrypted to
+// recipients need the matching private keys in IdentityFile.
+func Decrypt(src io.Reader, enc models.EncryptionConfig) (io.Reader, error) {
+	ids, err := identities(enc)
+	if err != nil {
+		return nil, err
+	}
+	r, err := age.Decrypt(src, ids...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt archive: %w", err)
+	}
+	return r, nil
+}
+
+// IsEncrypted reports whether an object key names an encrypted archive.
+func IsEncrypted(objectKey string) bool {
+	return strings.HasSuffix(objectKey, Extension)
+}
+
+func recipients(enc models.EncryptionConfig) ([]age.Recipient, error) {
+	if enc.Passphrase != "" {
+		r, err := age.NewScryptRecipient(enc.Passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("invalid passphrase: %w", err)
+		}
+		return []age.Recipient{r}, nil
+	}
+
+	rs := make([]age.Recipient, 0, len(enc.Recipients))
+	for _, recipient := range enc.Recipients {
+		r, err := age.ParseX25519Recipient(recipient)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %q: %w", recipient, err)
+		}
+		rs = append(rs, r)
+	}
+	return rs, nil
+}
+
+func identities(enc models.EncryptionConfig) ([]age.Identity, error) {
+	if enc.Passphrase != "" {
+		id, err := age.NewScryptIdentity(enc.Passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("invalid passphrase: %w", err)
+		}
+		return []age.Identity{id}, nil
+	}
+
+	if enc.IdentityFile == "" {
+		return nil, errors.New("decrypting an archive encrypted to recipients requires an identityFile")
+	}
+	file, err := os.Open(enc.IdentityFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open identity file: %w", err)
+	}
+	defer file.Close()
+
+	ids, err := age.ParseIdentities(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse identity file %q: %w", enc.IdentityFile, err)
+	}
+	return ids, nil
+}
@@ -6,30 +6,78 @@ import (
 	"io"
 	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
+	"time"
 
+	"github.com/tderick/backup-companion-go/internal/backup/filter"
+	"github.com/tderick/backup-companion-go/internal/backup/fsmeta"
+	"github.com/tderick/backup-companion-go/internal/backup/util"
 	"github.com/tderick/backup-companion-go/internal/models"
 )
 
-func BackupFilesOnly(ctx context.Context, cfg *models.Config, job models.JobConfig, backupDir string) {
+// BackupFilesOnly copies every directory referenced by the job into backupDir,
+// each below files/<source name>, and reports the outcome of each one. The
+// metadata copies could not be given is added to originals, by archive entry
+// name.
+func BackupFilesOnly(ctx context.Context, cfg *models.Config, job models.JobConfig, backupDir string, originals util.Originals) []models.SourceResult {
+	results := make([]models.SourceResult, 0, len(job.Directories))
 	for _, dirName := range job.Directories {
+		start := time.Now()
+		result := models.SourceResult{Name: dirName, Kind: "directory", Status: models.StatusSuccess}
+		result.ArchivePath = path.Join(util.FilesDir, dirName)
+
+		var err error
 		if dirConfig, ok := cfg.Sources.Directories[dirName]; ok {
-			BackupDirectory(ctx, dirConfig, backupDir)
+			result.Path, _ = filepath.Abs(dirConfig.Path)
+			dirOriginals := make(util.Originals)
+			err = BackupDirectory(ctx, dirName, dirConfig, filepath.Join(backupDir, filepath.FromSlash(result.ArchivePath)), dirOriginals)
+			for name, original := range dirOriginals {
+				originals[path.Join(result.ArchivePath, name)] = original
+			}
 		} else {
 			// This case should ideally be caught by validateReferences
+			err = fmt.Errorf("directory %q not found in sources", dirName)
 			slog.Error("Directory referenced by job not found in sources", "dirName", dirName, "job", job.Output)
 		}
+		if err != nil {
+			result.Status = models.StatusFailed
+			result.Error = err.Error()
+		}
+
+		result.Duration = time.Since(start)
+		results = append(results, result)
 	}
+	return results
 }
 
-// BackupDirectory recursively copies the contents of a source directory to the backup directory.
-func BackupDirectory(ctx context.Context, dir models.DirectoryConfig, backupDir string) {
+// BackupDirectory recursively copies the contents of a source directory to the backup directory,
+// leaving out what the source's filter excludes. Symlinks are copied as symlinks and hardlinks
+// as hardlinks, and every copy keeps the permissions, modification time and extended attributes
+// of its original, so that the archive records them. Copies are given the owner of their
+// original when running as root. The owner and extended attributes copies could not be
+// given are added to originals instead, keyed by the slash-separated path of the copy
+// relative to backupDir, for the archive to record.
+func BackupDirectory(ctx context.Context, name string, dir models.DirectoryConfig, backupDir string, originals util.Originals) error {
 	slog.Info("Backing up directory", "dir", dir.Path, "path", backupDir)
 
-	err := filepath.Walk(dir.Path, func(path string, info os.FileInfo, err error) error {
+	f, err := filter.New(name, dir)
+	if err != nil {
+		return fmt.Errorf("directory %q: %w", dir.Path, err)
+	}
+
+	links := make(map[fsmeta.FileID]string)
+	var dirs []copiedDir
+	err = filepath.Walk(dir.Path, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
+		if skip, err := f.Skip(path, info); err != nil || skip {
+			if skip && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return err
+		}
 
 		// Calculate relative path to maintain directory structure
 		relPath, err := filepath.Rel(dir.Path, path)
@@ -38,18 +86,117 @@ func BackupDirectory(ctx context.Context, dir models.DirectoryConfig, backupDir
 		}
 
 		targetPath := filepath.Join(backupDir, relPath)
+		name := filepath.ToSlash(relPath)
 
-		if info.IsDir() {
-			return os.MkdirAll(targetPath, info.Mode())
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			if err := os.MkdirAll(targetPath, 0700); err != nil {
+				return fmt.Errorf("failed to create directory %q: %v", targetPath, err)
+			}
+			dirs = append(dirs, copiedDir{name: name, source: path, target: targetPath, info: info})
+			return nil
+		case mode&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return fmt.Errorf("failed to read symlink %q: %v", path, err)
+			}
+			if err := os.Symlink(link, targetPath); err != nil {
+				return fmt.Errorf("failed to copy symlink %q: %v", path, err)
+			}
+		case mode.IsRegular():
+			if id, nlink, ok := fsmeta.Identity(info); ok && nlink > 1 {
+				if first, seen := links[id]; seen {
+					if err := os.Link(filepath.Join(backupDir, filepath.FromSlash(first)), targetPath); err != nil {
+						return fmt.Errorf("failed to copy hardlink %q: %v", path, err)
+					}
+					record(originals, name, originals[first])
+					return nil
+				}
+				links[id] = name
+			}
+			if err := efficientCopy(path, targetPath); err != nil {
+				return err
+			}
+		default:
+			slog.Warn("Skipping special file", "path", path, "mode", mode.String())
+			return nil
 		}
-
-		// Copy the file
-		return efficientCopy(path, targetPath)
+		original, err := copyMetadata(path, targetPath, info)
+		record(originals, name, original)
+		return err
 	})
 
+	// Directories get their metadata once their contents are copied, deepest
+	// first, so that copying does not change their modification times and
+	// read-only directories can still be filled.
+	for i := len(dirs) - 1; i >= 0 && err == nil; i-- {
+		var original util.Original
+		original, err = copyMetadata(dirs[i].source, dirs[i].target, dirs[i].info)
+		record(originals, dirs[i].name, original)
+	}
+
 	if err != nil {
 		slog.Error("Error backing up directory", "dir", dir.Path, "error", err)
+		return fmt.Errorf("failed to back up directory %q: %w", dir.Path, err)
 	}
+	f.LogSummary()
+	return nil
+}
+
+// copiedDir is a directory whose metadata is copied once its contents are.
+type copiedDir struct {
+	name   string
+	source string
+	target string
+	info   os.FileInfo
+}
+
+// record adds the metadata a copy could not be given to originals, if any.
+func record(originals util.Originals, name string, original util.Original) {
+	if originals != nil && (original.Owner != nil || original.Xattrs != nil) {
+		originals[name] = original
+	}
+}
+
+// canChown reports whether copies can be given the owner of their original,
+// and setXattrs sets the extended attributes of a copy. Tests replace them to
+// back up as if they were not running as root, or to a filesystem without
+// extended attributes.
+var (
+	canChown  = fsmeta.CanChown
+	setXattrs = fsmeta.SetXattrs
+)
+
+// copyMetadata gives dst the owner, permissions, extended attributes and
+// modification time of src, as described by info, and returns the metadata it
+// could not give it. The owner is only copied when running as root; extended
+// attributes may not be settable, e.g. those reserved to root or on a
+// filesystem without support for them.
+func copyMetadata(src, dst string, info os.FileInfo) (util.Original, error) {
+	var original util.Original
+	if uid, gid, ok := fsmeta.Owner(info); ok {
+		if !canChown() {
+			original.Owner = &util.Owner{UID: uid, GID: gid}
+		} else if err := os.Lchown(dst, uid, gid); err != nil {
+			return original, fmt.Errorf("failed to copy owner of %q: %v", src, err)
+		}
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		if err := os.Chmod(dst, fsmeta.Mode(info)); err != nil {
+			return original, fmt.Errorf("failed to copy permissions of %q: %v", src, err)
+		}
+	}
+
+	xattrs, err := fsmeta.Xattrs(src)
+	if err != nil {
+		return original, err
+	}
+	if err := setXattrs(dst, xattrs); err != nil {
+		slog.Debug("Failed to copy extended attributes, recording those of the original", "path", src, "error", err)
+		original.Xattrs = xattrs
+	}
+
+	return original, fsmeta.Chtimes(dst, info.ModTime())
 }
 
 // efficientCopy copies a file from src to dst using a buffer.
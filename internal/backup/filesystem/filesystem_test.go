@@ -0,0 +1,233 @@
+package filesystem
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tderick/backup-companion-go/internal/backup/fsmeta"
+	"github.com/tderick/backup-companion-go/internal/backup/util"
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+// testOwner is the owner given to the source tree when running as root.
+var testOwner = util.Owner{UID: 1234, GID: 5678}
+
+// newSourceTree creates a directory with a subdirectory, files with distinct
+// permissions, a hardlink, a symlink and an extended attribute, all with the
+// same modification time, owned by testOwner when running as root. It returns
+// the directory and the owner of its entries.
+func newSourceTree(t *testing.T) (string, util.Owner) {
+	t.Helper()
+	src := t.TempDir()
+	mustDo := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustDo(os.Mkdir(filepath.Join(src, "sub"), 0755))
+	mustDo(os.WriteFile(filepath.Join(src, "sub", "a.txt"), []byte("shared"), 0644))
+	mustDo(os.Link(filepath.Join(src, "sub", "a.txt"), filepath.Join(src, "sub", "b.txt")))
+	mustDo(os.WriteFile(filepath.Join(src, "run.sh"), []byte("#!/bin/sh\n"), 0644))
+	mustDo(os.Symlink("sub/a.txt", filepath.Join(src, "link")))
+	if err := fsmeta.SetXattrs(filepath.Join(src, "sub", "a.txt"), map[string]string{"user.comment": "kept"}); err != nil {
+		t.Logf("extended attributes are not supported here: %v", err)
+	}
+
+	mustDo(os.Chmod(filepath.Join(src, "sub", "a.txt"), 0640))
+	mustDo(os.Chmod(filepath.Join(src, "run.sh"), 0755))
+	mustDo(os.Chmod(filepath.Join(src, "sub"), 0750|fs.ModeSetgid))
+
+	owner := util.Owner{UID: os.Getuid(), GID: os.Getgid()}
+	if fsmeta.CanChown() {
+		owner = testOwner
+	}
+	mtime := time.Unix(1700000000, 0)
+	// Deepest first, so that setting the times of a directory's entries
+	// does not change its own.
+	for _, name := range []string{"link", "run.sh", "sub/b.txt", "sub/a.txt", "sub", "."} {
+		path := filepath.Join(src, filepath.FromSlash(name))
+		if fsmeta.CanChown() {
+			mustDo(os.Lchown(path, owner.UID, owner.GID))
+		}
+		mustDo(fsmeta.Chtimes(path, mtime))
+	}
+	return src, owner
+}
+
+// readHeaders returns the header of every entry of an uncompressed archive.
+func readHeaders(t *testing.T, archive string) map[string]*tar.Header {
+	t.Helper()
+	file, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	headers := make(map[string]*tar.Header)
+	tarReader := tar.NewReader(file)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return headers
+		}
+		if err != nil {
+			t.Fatalf("failed to read archive: %v", err)
+		}
+		headers[header.Name] = header
+	}
+}
+
+// compareTrees reports every entry of src whose copy in dst differs in type,
+// permissions, modification time, symlink target or extended attributes, or
+// in owner when checkOwner is set.
+func compareTrees(t *testing.T, src, dst string, owner util.Owner, checkOwner bool) {
+	t.Helper()
+	err := filepath.Walk(src, func(path string, want os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		got, err := os.Lstat(target)
+		if err != nil {
+			t.Errorf("%s: %v", rel, err)
+			return nil
+		}
+
+		if got.Mode() != want.Mode() {
+			t.Errorf("%s: mode = %v, want %v", rel, got.Mode(), want.Mode())
+		}
+		if !got.ModTime().Equal(want.ModTime()) {
+			t.Errorf("%s: modification time = %v, want %v", rel, got.ModTime(), want.ModTime())
+		}
+		if checkOwner {
+			if uid, gid, ok := fsmeta.Owner(got); ok && (uid != owner.UID || gid != owner.GID) {
+				t.Errorf("%s: owner = %d:%d, want %d:%d", rel, uid, gid, owner.UID, owner.GID)
+			}
+		}
+		if want.Mode()&os.ModeSymlink != 0 {
+			wantLink, _ := os.Readlink(path)
+			if gotLink, err := os.Readlink(target); err != nil || gotLink != wantLink {
+				t.Errorf("%s: symlink target = %q, %v, want %q", rel, gotLink, err, wantLink)
+			}
+			return nil
+		}
+
+		wantXattrs, err := fsmeta.Xattrs(path)
+		if err != nil {
+			return err
+		}
+		gotXattrs, err := fsmeta.Xattrs(target)
+		if err != nil {
+			return err
+		}
+		if !maps.Equal(gotXattrs, wantXattrs) {
+			t.Errorf("%s: extended attributes = %v, want %v", rel, gotXattrs, wantXattrs)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackupDirectoryRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		canChown bool
+		noXattrs bool
+	}{
+		{"copying owners", true, false},
+		// The staged copies belong to the user running the backup, so the
+		// archive must take their owners from the sources.
+		{"not copying owners", false, false},
+		// The staging directory cannot hold extended attributes, so the
+		// archive must take them from the sources.
+		{"not copying extended attributes", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.canChown && !fsmeta.CanChown() {
+				t.Skip("copying owners requires root")
+			}
+			defer func(original func() bool) { canChown = original }(canChown)
+			canChown = func() bool { return tt.canChown }
+			defer func(original func(string, map[string]string) error) { setXattrs = original }(setXattrs)
+			if tt.noXattrs {
+				setXattrs = func(string, map[string]string) error { return errors.New("operation not supported") }
+			}
+
+			src, owner := newSourceTree(t)
+			cfg := &models.Config{Sources: models.SourcesConfig{
+				Directories: map[string]models.DirectoryConfig{"app": {Path: src}},
+			}}
+			job := models.JobConfig{Directories: []string{"app"}}
+			backupDir := t.TempDir()
+			originals := make(util.Originals)
+			results := BackupFilesOnly(context.Background(), cfg, job, backupDir, originals)
+			if len(results) != 1 || results[0].Status != models.StatusSuccess {
+				t.Fatalf("BackupFilesOnly() = %+v, want one successful source", results)
+			}
+
+			archive := filepath.Join(t.TempDir(), "backup.tar")
+			if err := util.CreateArchive(backupDir, archive, models.CompressionConfig{Algorithm: "none"}, nil, originals, nil); err != nil {
+				t.Fatalf("CreateArchive() error = %v", err)
+			}
+
+			// Every entry records the owner of its original
+			headers := readHeaders(t, archive)
+			for _, name := range []string{"files/app", "files/app/sub", "files/app/sub/a.txt", "files/app/sub/b.txt", "files/app/run.sh", "files/app/link"} {
+				header, ok := headers[name]
+				if !ok {
+					t.Errorf("archive has no entry %q", name)
+					continue
+				}
+				if header.Uid != owner.UID || header.Gid != owner.GID {
+					t.Errorf("%s: recorded owner = %d:%d, want %d:%d", name, header.Uid, header.Gid, owner.UID, owner.GID)
+				}
+			}
+			wantXattrs, err := fsmeta.Xattrs(filepath.Join(src, "sub", "a.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header, ok := headers["files/app/sub/a.txt"]; ok {
+				for name, value := range wantXattrs {
+					if got := header.PAXRecords[fsmeta.PAXPrefix+name]; got != value {
+						t.Errorf("files/app/sub/a.txt: recorded %s = %q, want %q", name, got, value)
+					}
+				}
+			}
+
+			restored := t.TempDir()
+			if err := util.ExtractArchive(archive, restored); err != nil {
+				t.Fatalf("ExtractArchive() error = %v", err)
+			}
+			restoredSrc := filepath.Join(restored, "files", "app")
+			compareTrees(t, src, restoredSrc, owner, fsmeta.CanChown())
+
+			a, err := os.Stat(filepath.Join(restoredSrc, "sub", "a.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := os.Stat(filepath.Join(restoredSrc, "sub", "b.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, ok := fsmeta.Identity(a); ok && !os.SameFile(a, b) {
+				t.Error("sub/a.txt and sub/b.txt were restored as separate files, want hardlinks")
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+// Package filter decides which entries of a directory source are backed up,
+// from the source's include and exclude patterns, its size limit, marker
+// files and .backupignore files.
+package filter
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+// IgnoreFileName is the name of the files listing, in gitignore syntax,
+// entries of their directory to leave out.
+const IgnoreFileName = ".backupignore"
+
+// Filter selects the entries of one directory source. It is used for a
+// single walk of the source, in lexical order, as it loads .backupignore
+// files from the directories it keeps and counts what it leaves out.
+type Filter struct {
+	source           string
+	root             string
+	include          []string
+	exclude          []string
+	maxFileSize      int64
+	excludeIfPresent []string
+	ignoreRules      []ignoreRule
+
+	excluded      int
+	excludedBytes int64
+}
+
+// ignoreRule is a line of a .backupignore file found in dir.
+type ignoreRule struct {
+	dir     string
+	pattern string
+	negate  bool
+	dirOnly bool
+}
+
+// New returns a filter for the named directory source.
+func New(source string, dir models.DirectoryConfig) (*Filter, error) {
+	if err := Validate(dir); err != nil {
+		return nil, err
+	}
+	maxFileSize, _ := ParseSize(dir.MaxFileSize)
+
+	return &Filter{
+		source:           source,
+		root:             filepath.Clean(dir.Path),
+		include:          dir.Include,
+		exclude:          dir.Exclude,
+		maxFileSize:      maxFileSize,
+		excludeIfPresent: dir.ExcludeIfPresent,
+	}, nil
+}
+
+// Validate checks the patterns and size limit of a directory source.
+func Validate(dir models.DirectoryConfig) error {
+	for _, pattern := range append(append([]string{}, dir.Include...), dir.Exclude...) {
+		if !doublestar.ValidatePattern(pattern) {
+			return fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+	if _, err := ParseSize(dir.MaxFileSize); err != nil {
+		return fmt.Errorf("invalid maxFileSize: %w", err)
+	}
+	for _, name := range dir.ExcludeIfPresent {
+		if name == "" || strings.ContainsRune(name, '/') {
+			return fmt.Errorf("invalid excludeIfPresent file name %q", name)
+		}
+	}
+	return nil
+}
+
+// Skip reports whether the entry at path, found while walking the source,
+// is left out. When it is a directory, its contents are left out too and
+// the walk must skip it. A nil filter keeps everything.
+func (f *Filter) Skip(path string, info fs.FileInfo) (bool, error) {
+	if f == nil {
+		return false, nil
+	}
+
+	relPath, err := filepath.Rel(f.root, path)
+	if err != nil {
+		return false, fmt.Errorf("failed to get relative path for %q: %v", path, err)
+	}
+	relPath = filepath.ToSlash(relPath)
+
+	skip, err := f.skip(path, relPath, info)
+	if err != nil || !skip {
+		return false, err
+	}
+
+	f.excluded++
+	if info.IsDir() {
+		f.excludedBytes += treeSize(path)
+	} else if info.Mode().IsRegular() {
+		f.excludedBytes += info.Size()
+	}
+	slog.Debug("Excluding entry from backup", "source", f.source, "path", path)
+	return true, nil
+}
+
+func (f *Filter) skip(path, relPath string, info fs.FileInfo) (bool, error) {
+	if relPath != "." {
+		if matchAny(f.exclude, relPath) || f.ignored(relPath, info.IsDir()) {
+			return true, nil
+		}
+	}
+
+	if !info.IsDir() {
+		if len(f.include) > 0 && !matchAny(f.include, relPath) {
+			return true, nil
+		}
+		return info.Mode().IsRegular() && f.maxFileSize > 0 && info.Size() > f.maxFileSize, nil
+	}
+
+	for _, name := range f.excludeIfPresent {
+		if _, err := os.Lstat(filepath.Join(path, name)); err == nil {
+			return true, nil
+		}
+	}
+	return false, f.loadIgnoreFile(path, relPath)
+}
+
+// LogSummary logs how many entries were left out of the source, and the
+// bytes they hold.
+func (f *Filter) LogSummary() {
+	if f == nil {
+		return
+	}
+	slog.Info("Filtered directory", "source", f.source, "dir", f.root, "excluded", f.excluded, "excluded_bytes", f.excludedBytes)
+}
+
+// ignored applies the rules of the .backupignore files of relPath's parent
+// directories. As in gitignore, the last matching rule wins and rules only
+// apply below the directory holding them.
+func (f *Filter) ignored(relPath string, isDir bool) bool {
+	ignored := false
+	for _, rule := range f.ignoreRules {
+		name := relPath
+		if rule.dir != "." {
+			var found bool
+			if name, found = strings.CutPrefix(relPath, rule.dir+"/"); !found {
+				continue
+			}
+		}
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if matched, _ := doublestar.Match(rule.pattern, name); matched {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// loadIgnoreFile adds the rules of the .backupignore file of a directory, if
+// it has one.
+func (f *Filter) loadIgnoreFile(dir, relDir string) error {
+	file, err := os.Open(filepath.Join(dir, IgnoreFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", IgnoreFileName, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{dir: relDir}
+		if rule.negate = strings.HasPrefix(line, "!"); rule.negate {
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, `\`) // Escapes a leading "#" or "!"
+		if rule.dirOnly = strings.HasSuffix(line, "/"); rule.dirOnly {
+			line = strings.TrimSuffix(line, "/")
+		}
+		// A pattern without a slash matches at any depth; any other
+		// pattern is relative to the directory of the file.
+		if strings.Contains(line, "/") {
+			line = strings.TrimPrefix(line, "/")
+		} else {
+			line = "**/" + line
+		}
+		if line == "" || !doublestar.ValidatePattern(line) {
+			slog.Warn("Ignoring invalid pattern", "file", path.Join(relDir, IgnoreFileName), "pattern", scanner.Text())
+			continue
+		}
+
+		rule.pattern = line
+		f.ignoreRules = append(f.ignoreRules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", IgnoreFileName, err)
+	}
+	return nil
+}
+
+// matchAny reports whether name matches one of the patterns.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := doublestar.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// treeSize returns the total size of the regular files below dir, ignoring
+// anything it cannot read.
+func treeSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.Type().IsRegular() {
+			if info, err := entry.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// sizeUnits maps the suffixes ParseSize accepts to their number of bytes.
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+// ParseSize parses a size such as "512", "100MB" or "1.5GiB" into bytes. An
+// empty size is 0, meaning no limit.
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	number, multiplier := s, int64(1)
+	for _, unit := range sizeUnits {
+		if trimmed, found := strings.CutSuffix(s, unit.suffix); found {
+			number, multiplier = strings.TrimSpace(trimmed), unit.bytes
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%q is not a size such as \"100MiB\"", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
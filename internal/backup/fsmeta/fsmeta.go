@@ -0,0 +1,45 @@
+// Package fsmeta reads and writes the filesystem metadata that os does not
+// cover: extended attributes, which also hold POSIX ACLs, inode identities
+// for detecting hardlinks, and the times of symlinks. It is only functional
+// on Linux; elsewhere there is no metadata to read and writing is a no-op.
+package fsmeta
+
+import (
+	"io/fs"
+	"os"
+	"time"
+)
+
+// PAXPrefix starts the PAX record of each extended attribute in a tar header,
+// as written by GNU tar and star.
+const PAXPrefix = "SCHILY.xattr."
+
+// FileID identifies a file across its hardlinks.
+type FileID struct {
+	Dev uint64
+	Ino uint64
+}
+
+// CanChown reports whether the process may give files any owner, which is
+// required to preserve ownership.
+func CanChown() bool {
+	return os.Geteuid() == 0
+}
+
+// Mode returns the permission bits of info, including the setuid, setgid
+// and sticky bits, in the form os.Chmod expects.
+func Mode(info fs.FileInfo) fs.FileMode {
+	return info.Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+}
+
+// Chmod sets the permissions of path, as returned by Mode, without following
+// a symlink. The permissions of a symlink itself are left as they are.
+func Chmod(path string, mode fs.FileMode) error {
+	return lchmod(path, mode)
+}
+
+// Chtimes sets the modification time of path without following a symlink.
+// The access time is set to the same value.
+func Chtimes(path string, mtime time.Time) error {
+	return lchtimes(path, mtime)
+}
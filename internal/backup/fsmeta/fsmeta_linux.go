@@ -0,0 +1,133 @@
+package fsmeta
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Xattrs returns the extended attributes of path, without following a
+// symlink. Filesystems without extended attributes have none.
+func Xattrs(path string) (map[string]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if errors.Is(err, unix.ENOTSUP) || size == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list extended attributes of %q: %w", path, err)
+	}
+	names := make([]byte, size)
+	if size, err = unix.Llistxattr(path, names); err != nil {
+		return nil, fmt.Errorf("failed to list extended attributes of %q: %w", path, err)
+	}
+
+	xattrs := make(map[string]string)
+	for _, name := range bytes.Split(names[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, err := getxattr(path, string(name))
+		if errors.Is(err, unix.ENODATA) {
+			continue // Removed since it was listed
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read extended attribute %q of %q: %w", name, path, err)
+		}
+		xattrs[string(name)] = string(value)
+	}
+	return xattrs, nil
+}
+
+func getxattr(path, name string) ([]byte, error) {
+	size, err := unix.Lgetxattr(path, name, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	value := make([]byte, size)
+	size, err = unix.Lgetxattr(path, name, value)
+	if err != nil {
+		return nil, err
+	}
+	return value[:size], nil
+}
+
+// SetXattrs sets extended attributes of path, without following a symlink.
+// Every attribute is attempted; the errors of those that could not be set,
+// e.g. for lack of privileges, are joined.
+func SetXattrs(path string, xattrs map[string]string) error {
+	var errs []error
+	for name, value := range xattrs {
+		if err := unix.Lsetxattr(path, name, []byte(value), 0); err != nil {
+			errs = append(errs, fmt.Errorf("failed to set extended attribute %q of %q: %w", name, path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Identity returns the inode identity of the file info describes and its
+// number of hardlinks.
+func Identity(info fs.FileInfo) (FileID, uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return FileID{}, 0, false
+	}
+	return FileID{Dev: uint64(stat.Dev), Ino: stat.Ino}, uint64(stat.Nlink), true
+}
+
+// Owner returns the user and group owning the file info describes.
+func Owner(info fs.FileInfo) (uid, gid int, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(stat.Uid), int(stat.Gid), true
+}
+
+func lchtimes(path string, mtime time.Time) error {
+	ts := unix.NsecToTimespec(mtime.UnixNano())
+	if err := unix.UtimesNanoAt(unix.AT_FDCWD, path, []unix.Timespec{ts, ts}, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return fmt.Errorf("failed to set modification time of %q: %w", path, err)
+	}
+	return nil
+}
+
+func lchmod(path string, mode fs.FileMode) error {
+	perm := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		perm |= unix.S_ISUID
+	}
+	if mode&fs.ModeSetgid != 0 {
+		perm |= unix.S_ISGID
+	}
+	if mode&fs.ModeSticky != 0 {
+		perm |= unix.S_ISVTX
+	}
+
+	// fchmodat2 (Linux 6.6) refuses symlinks with EOPNOTSUPP, which is also
+	// returned by older kernels that lack it. Either way, fall back to an fd
+	// opened without following a symlink.
+	err := unix.Fchmodat(unix.AT_FDCWD, path, perm, unix.AT_SYMLINK_NOFOLLOW)
+	if !errors.Is(err, unix.EOPNOTSUPP) {
+		if err != nil {
+			return fmt.Errorf("failed to set permissions of %q: %w", path, err)
+		}
+		return nil
+	}
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if errors.Is(err, unix.ELOOP) {
+		return nil // A symlink
+	}
+	if err == nil {
+		err = unix.Fchmod(fd, perm)
+		unix.Close(fd)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set permissions of %q: %w", path, err)
+	}
+	return nil
+}
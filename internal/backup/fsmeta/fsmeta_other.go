@@ -0,0 +1,45 @@
+//go:build !linux
+
+package fsmeta
+
+import (
+	"io/fs"
+	"os"
+	"time"
+)
+
+// Xattrs returns no extended attributes.
+func Xattrs(path string) (map[string]string, error) {
+	return nil, nil
+}
+
+// SetXattrs does nothing.
+func SetXattrs(path string, xattrs map[string]string) error {
+	return nil
+}
+
+// Identity reports that hardlinks cannot be detected.
+func Identity(info fs.FileInfo) (FileID, uint64, bool) {
+	return FileID{}, 0, false
+}
+
+// Owner reports that the owner is unknown.
+func Owner(info fs.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
+
+func lchtimes(path string, mtime time.Time) error {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSymlink != 0 {
+		return err // The times of symlinks are left as they are
+	}
+	return os.Chtimes(path, mtime, mtime)
+}
+
+func lchmod(path string, mode fs.FileMode) error {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSymlink != 0 {
+		return err // The permissions of symlinks are left as they are
+	}
+	return os.Chmod(path, mode)
+}
@@ -0,0 +1,136 @@
+// Package manifest describes the contents of a backup archive. Every archive
+// ends with a MANIFEST.json entry, and the same manifest is uploaded next to
+// the archive so that it can be inspected without downloading the archive.
+package manifest
+
+import (
+	"archive/tar"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tderick/backup-companion-go/internal/backup/encryption"
+	"github.com/tderick/backup-companion-go/internal/models"
+	"github.com/tderick/backup-companion-go/internal/version"
+)
+
+const (
+	// FileName is the name of the manifest entry in the archive.
+	FileName = "MANIFEST.json"
+	// SidecarExtension is appended to the object key of an archive to name
+	// the manifest uploaded next to it.
+	SidecarExtension = ".manifest.json"
+	// FormatVersion is incremented on incompatible changes to the manifest.
+	FormatVersion = 1
+)
+
+// Manifest describes a backup archive.
+type Manifest struct {
+	FormatVersion int       `json:"formatVersion"`
+	ToolVersion   string    `json:"toolVersion"`
+	Hostname      string    `json:"hostname"`
+	Job           string    `json:"job"`
+	StartedAt     time.Time `json:"startedAt"`
+	FinishedAt    time.Time `json:"finishedAt"`
+	// Sources are the outcomes of backing up each directory and database.
+	Sources []models.SourceResult `json:"sources"`
+	// Files are the regular files in the archive, in archive order.
+	Files []File `json:"files"`
+}
+
+// File describes a regular file in the archive.
+type File struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Mode    string    `json:"mode"` // Octal permission bits, e.g. "0644"
+	SHA256  string    `json:"sha256"`
+}
+
+// New starts the manifest of an archive of the job.
+func New(job string, startedAt time.Time) *Manifest {
+	hostname, _ := os.Hostname()
+	return &Manifest{
+		FormatVersion: FormatVersion,
+		ToolVersion:   version.String(),
+		Hostname:      hostname,
+		Job:           job,
+		StartedAt:     startedAt,
+	}
+}
+
+// AddFile records a regular file written to the archive with the given
+// header and SHA-256 checksum.
+func (m *Manifest) AddFile(header *tar.Header, sum []byte) {
+	m.Files = append(m.Files, File{
+		Path:    header.Name,
+		Size:    header.Size,
+		ModTime: header.ModTime,
+		Mode:    fmt.Sprintf("%04o", header.Mode&0o7777),
+		SHA256:  hex.EncodeToString(sum),
+	})
+}
+
+// Finish records the outcome of the sources and the end of the backup.
+func (m *Manifest) Finish(sources []models.SourceResult) {
+	m.Sources = sources
+	m.FinishedAt = time.Now()
+}
+
+// Marshal encodes the manifest as indented JSON.
+func (m *Manifest) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	return data, nil
+}
+
+// WriteTo adds the manifest to the archive as its last entry.
+func (m *Manifest) WriteTo(tarWriter *tar.Writer) error {
+	data, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+
+	header := &tar.Header{
+		Name:     FileName,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  m.FinishedAt,
+	}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write manifest header: %w", err)
+	}
+	if _, err := tarWriter.Write(data); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// Read decodes a manifest.
+func Read(r io.Reader) (*Manifest, error) {
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	if m.FormatVersion > FormatVersion {
+		return nil, fmt.Errorf("unsupported manifest format version %d", m.FormatVersion)
+	}
+	return &m, nil
+}
+
+// SidecarKey returns the object key of the manifest uploaded next to the
+// archive with the given key. The manifest of an encrypted archive is
+// encrypted too, since it lists every file name.
+func SidecarKey(archiveKey string) string {
+	if strings.HasSuffix(archiveKey, encryption.Extension) {
+		return archiveKey + SidecarExtension + encryption.Extension
+	}
+	return archiveKey + SidecarExtension
+}
@@ -0,0 +1,152 @@
+// Package objectkey lays out the archives of a job on a destination. A
+// destination, or a job, can set a prefix template that is prepended to the
+// name of every archive, e.g. "{{.Host}}/{{.Job}}/{{.Date "2006/01"}}/", so
+// that several servers and jobs can share a bucket.
+package objectkey
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/tderick/backup-companion-go/internal/backup/util"
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+// dateMarker stands for the output of Date when computing the list prefix.
+const dateMarker = "\x00"
+
+// Effective returns the prefix template that applies to a job on a
+// destination: the job's own prefix if it has one, otherwise the
+// destination's. An empty template stores archives at the root.
+func Effective(job models.JobConfig, dest models.DestinationConfig) string {
+	if job.Prefix != "" {
+		return job.Prefix
+	}
+	return dest.Prefix
+}
+
+// Validate checks that a prefix template parses and renders.
+func Validate(text string) error {
+	layout, err := newLayout(text, "job", "job")
+	if err != nil {
+		return err
+	}
+	_, err = layout.Prefix(time.Now())
+	return err
+}
+
+// vars are the variables available to prefix templates.
+type vars struct {
+	// Job is the name of the job in the config file.
+	Job string
+	// Host is the hostname of the machine taking the backup.
+	Host string
+	t    time.Time
+	date func(t time.Time, layout string) string
+}
+
+// Date formats the backup time with a Go time layout, e.g. "2006/01/02".
+func (v vars) Date(layout string) string {
+	return v.date(v.t, layout)
+}
+
+// Env returns the value of an environment variable.
+func (v vars) Env(name string) string {
+	return os.Getenv(name)
+}
+
+// Layout lays out the archives of one job on one destination.
+type Layout struct {
+	tmpl    *template.Template // nil when archives are stored at the root
+	jobName string
+	// name is the output name that starts every archive name.
+	name string
+}
+
+// New returns the layout of the job's archives on the destination.
+func New(jobName string, job models.JobConfig, dest models.DestinationConfig) (*Layout, error) {
+	return newLayout(Effective(job, dest), jobName, job.Output.Name)
+}
+
+func newLayout(text, jobName, name string) (*Layout, error) {
+	layout := &Layout{jobName: jobName, name: name}
+	if text == "" {
+		return layout, nil
+	}
+
+	tmpl, err := template.New("prefix").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prefix template %q: %w", text, err)
+	}
+	layout.tmpl = tmpl
+	return layout, nil
+}
+
+// render executes the template with the given backup time and Date function.
+func (l *Layout) render(t time.Time, date func(time.Time, string) string) (string, error) {
+	if l.tmpl == nil {
+		return "", nil
+	}
+
+	hostname, _ := os.Hostname()
+	var b strings.Builder
+	err := l.tmpl.Execute(&b, vars{Job: l.jobName, Host: hostname, t: t, date: date})
+	if err != nil {
+		return "", fmt.Errorf("failed to render prefix template: %w", err)
+	}
+	// Keys starting with a slash show up as an empty folder on most providers.
+	return strings.TrimLeft(b.String(), "/"), nil
+}
+
+// Prefix returns the prefix of the archives of a backup taken at t.
+func (l *Layout) Prefix(t time.Time) (string, error) {
+	return l.render(t, time.Time.Format)
+}
+
+// Key returns the object key of the archive with the given name, taken at t.
+func (l *Layout) Key(archiveName string, t time.Time) (string, error) {
+	prefix, err := l.Prefix(t)
+	if err != nil {
+		return "", err
+	}
+	return prefix + archiveName, nil
+}
+
+// ListPrefix returns a prefix shared by the keys of every archive of the
+// job, whenever it was taken: the prefix up to its first date-dependent
+// part, or up to the archive names if it has none.
+func (l *Layout) ListPrefix() (string, error) {
+	prefix, err := l.render(time.Time{}, func(time.Time, string) string { return dateMarker })
+	if err != nil {
+		return "", err
+	}
+	if i := strings.Index(prefix, dateMarker); i >= 0 {
+		return prefix[:i], nil
+	}
+	return prefix + l.name + "-", nil
+}
+
+// Match reports whether key is the key of an archive of the job in this
+// layout, and returns the backup time encoded in its name. Objects of other
+// hosts or jobs sharing the list prefix do not match.
+func (l *Layout) Match(key string) (time.Time, bool) {
+	// The prefix need not end with a slash, e.g. "{{.Host}}-", so the archive
+	// name may start anywhere in the last element of the key.
+	for i := strings.LastIndex(key, "/") + 1; i < len(key); i++ {
+		archiveName := key[i:]
+		if !strings.HasPrefix(archiveName, l.name+"-") {
+			continue
+		}
+		t, ok := util.ParseArchiveName(l.name, archiveName)
+		if !ok {
+			continue
+		}
+		if expected, err := l.Key(archiveName, t); err == nil && expected == key {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,129 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/tderick/backup-companion-go/internal/backup/manifest"
+	"github.com/tderick/backup-companion-go/internal/backup/objectkey"
+	"github.com/tderick/backup-companion-go/internal/backup/remotestorage"
+	"github.com/tderick/backup-companion-go/internal/backup/retention"
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+// Prune deletes the remote backups of the named jobs that fall outside their
+// retention policy. With dryRun set it only logs what would be deleted.
+// Jobs and destinations without a retention policy are left untouched.
+func Prune(ctx context.Context, cfg *models.Config, jobNames []string, dryRun bool) error {
+	var pruneErrors []error
+	for _, jobName := range jobNames {
+		job := cfg.Jobs[jobName]
+		for _, destName := range job.Destinations {
+			destConfig, ok := cfg.Destinations[destName]
+			if !ok {
+				pruneErrors = append(pruneErrors, fmt.Errorf("destination %q referenced by job %q not found in config", destName, jobName))
+				continue
+			}
+
+			policy := retention.Effective(job, destConfig)
+			if policy == nil {
+				slog.Info("No retention policy, skipping prune", "job_name", jobName, "destination", destName)
+				continue
+			}
+
+			if err := pruneDestination(ctx, jobName, job, destName, destConfig, *policy, dryRun); err != nil {
+				slog.Error("Failed to prune destination", "job_name", jobName, "destination", destName, "error", err)
+				pruneErrors = append(pruneErrors, fmt.Errorf("job %q, destination %q: %w", jobName, destName, err))
+			}
+		}
+	}
+	return errors.Join(pruneErrors...)
+}
+
+// pruneDestination applies the retention policy to one job's backups on one destination.
+func pruneDestination(ctx context.Context, jobName string, job models.JobConfig, destName string, destConfig models.DestinationConfig, policy models.RetentionConfig, dryRun bool) error {
+	dest, err := remotestorage.New(ctx, destConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create destination client: %w", err)
+	}
+
+	layout, err := objectkey.New(jobName, job, destConfig)
+	if err != nil {
+		return err
+	}
+	archives, err := listJobArchives(ctx, dest, layout)
+	if err != nil {
+		return err
+	}
+
+	snapshots := make([]retention.Snapshot, 0, len(archives))
+	for _, archive := range archives {
+		snapshots = append(snapshots, retention.Snapshot{Key: archive.Key, Time: archive.Time})
+	}
+
+	keep, remove := retention.Apply(policy, snapshots, time.Now())
+	slog.Info("Applying retention policy",
+		"job_name", jobName,
+		"destination", destName,
+		"keep", len(keep),
+		"remove", len(remove),
+		"dry_run", dryRun,
+	)
+
+	var deleteErrors []error
+	for _, snapshot := range remove {
+		if dryRun {
+			slog.Info("Would delete backup", "job_name", jobName, "destination", destName, "key", snapshot.Key)
+			continue
+		}
+		if err := dest.Delete(ctx, snapshot.Key); err != nil {
+			deleteErrors = append(deleteErrors, err)
+			continue
+		}
+		slog.Info("Deleted backup", "job_name", jobName, "destination", destName, "key", snapshot.Key)
+
+		// Older backups have no manifest next to them.
+		sidecarKey := manifest.SidecarKey(snapshot.Key)
+		if _, err := dest.Stat(ctx, sidecarKey); err == nil {
+			if err := dest.Delete(ctx, sidecarKey); err != nil {
+				deleteErrors = append(deleteErrors, err)
+			}
+		} else if !errors.Is(err, remotestorage.ErrNotFound) {
+			deleteErrors = append(deleteErrors, err)
+		}
+	}
+	return errors.Join(deleteErrors...)
+}
+
+// archiveObject is a job archive stored on a destination.
+type archiveObject struct {
+	remotestorage.ObjectInfo
+	// Time is the backup time encoded in the object name.
+	Time time.Time
+}
+
+// listJobArchives lists the archives of a job stored on a destination with
+// the given layout. Objects that do not follow the archive naming scheme or
+// the layout, such as those of other hosts sharing the destination, are
+// left out.
+func listJobArchives(ctx context.Context, dest remotestorage.Destination, layout *objectkey.Layout) ([]archiveObject, error) {
+	prefix, err := layout.ListPrefix()
+	if err != nil {
+		return nil, err
+	}
+	objects, err := dest.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var archives []archiveObject
+	for _, object := range objects {
+		if t, ok := layout.Match(object.Key); ok {
+			archives = append(archives, archiveObject{ObjectInfo: object, Time: t})
+		}
+	}
+	return archives, nil
+}
@@ -0,0 +1,70 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tderick/backup-companion-go/internal/backup/objectkey"
+	"github.com/tderick/backup-companion-go/internal/backup/remotestorage"
+	"github.com/tderick/backup-companion-go/internal/backup/util"
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+func TestListJobArchivesFindsUploadedArchive(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	taken := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)
+
+	tests := []struct {
+		name    string
+		prefix  string
+		wantKey string
+	}{
+		{"no prefix", "", "app-2024-01-02-03-04-05.tar.gz"},
+		{"directory prefix", "{{.Host}}/{{.Job}}/", hostname + "/job/app-2024-01-02-03-04-05.tar.gz"},
+		{"prefix without a slash", "{{.Host}}-", hostname + "-app-2024-01-02-03-04-05.tar.gz"},
+		{"dated prefix without a slash", `backups/{{.Date "2006"}}-`, "backups/2024-app-2024-01-02-03-04-05.tar.gz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			job := models.JobConfig{Output: models.OutputConfig{Name: "app"}, Prefix: tt.prefix}
+			dest := remotestorage.NewLocalDestination(models.DestinationConfig{Provider: "local", Path: t.TempDir()})
+			layout, err := objectkey.New("job", job, models.DestinationConfig{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			key, err := layout.Key(util.BackupName(job.Output, taken)+".tar.gz", taken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key != tt.wantKey {
+				t.Fatalf("Key() = %q, want %q", key, tt.wantKey)
+			}
+			// The archive of another job sharing the prefix is not listed
+			other, err := layout.Key("application-2024-01-02-03-04-05.tar.gz", taken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, k := range []string{key, other} {
+				if err := dest.Upload(ctx, strings.NewReader("archive"), k, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			archives, err := listJobArchives(ctx, dest, layout)
+			if err != nil {
+				t.Fatalf("listJobArchives() error = %v", err)
+			}
+			if len(archives) != 1 || archives[0].Key != key || !archives[0].Time.Equal(taken) {
+				t.Errorf("listJobArchives() = %+v, want %q taken at %v", archives, key, taken)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package remotestorage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+func init() {
+	Register("azureblob", Provider{
+		New: func(ctx context.Context, cfg models.DestinationConfig) (Destination, error) {
+			return NewAzureBlobDestination(cfg)
+		},
+		CheckConfig: checkAzureBlobConfig,
+	})
+}
+
+// checkAzureBlobConfig reports missing settings of an Azure Blob Storage
+// destination. The account key or SAS token is checked along with the other
+// secrets by the config package.
+func checkAzureBlobConfig(cfg models.DestinationConfig) error {
+	var missing []string
+	if cfg.AccountName == "" {
+		missing = append(missing, "accountName")
+	}
+	if cfg.Container == "" {
+		missing = append(missing, "container")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+	if cfg.AccountKey != "" && cfg.SASToken != "" {
+		return errors.New("set either accountKey or sasToken, not both")
+	}
+	return nil
+}
+
+// AzureBlobDestination stores objects as block blobs in an Azure Blob Storage
+// container.
+type AzureBlobDestination struct {
+	client    *container.Client
+	container string
+	upload    uploadOptions
+}
+
+// NewAzureBlobDestination creates a client for the container, authenticated
+// with the account's shared key or a SAS token. EndpointURL replaces the
+// account's public blob endpoint, e.g. for the Azurite emulator.
+func NewAzureBlobDestination(cfg models.DestinationConfig) (*AzureBlobDestination, error) {
+	upload := newUploadOptions(cfg.Upload)
+
+	serviceURL := cfg.EndpointURL
+	if serviceURL == "" {
+		serviceURL = fmt.Sprintf("https://%s.blob.core.windows.net", cfg.AccountName)
+	}
+	containerURL := strings.TrimSuffix(serviceURL, "/") + "/" + cfg.Container
+
+	options := &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Retry: policy.RetryOptions{MaxRetries: int32(upload.maxRetries)},
+		},
+	}
+
+	var client *container.Client
+	var err error
+	if cfg.AccountKey != "" {
+		cred, credErr := container.NewSharedKeyCredential(cfg.AccountName, cfg.AccountKey)
+		if credErr != nil {
+			return nil, fmt.Errorf("invalid account key for storage account %q: %w", cfg.AccountName, credErr)
+		}
+		client, err = container.NewClientWithSharedKeyCredential(containerURL, cred, options)
+	} else {
+		client, err = container.NewClientWithNoCredential(containerURL+"?"+strings.TrimPrefix(cfg.SASToken, "?"), options)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client for container %q: %w", cfg.Container, err)
+	}
+
+	return &AzureBlobDestination{
+		client:    client,
+		container: cfg.Container,
+		upload:    upload,
+	}, nil
+}
+
+// Validate checks that the container exists and is accessible.
+func (d *AzureBlobDestination) Validate(ctx context.Context) error {
+	if _, err := d.client.GetProperties(ctx, nil); err != nil {
+		return fmt.Errorf("failed to validate Azure connection for container %q: %w", d.container, err)
+	}
+	return nil
+}
+
+// Upload uploads everything read from r as a block blob. The stream is
+// staged block by block, with up to the destination's concurrency in flight,
+// and committed once complete. Each block carries a CRC64 that the service
+// checks on receipt, and the blob records the MD5 of the whole stream.
+func (d *AzureBlobDestination) Upload(ctx context.Context, r io.Reader, objectKey string, metadata map[string]string) error {
+	headers := &blob.HTTPHeaders{}
+	_, err := d.client.NewBlockBlobClient(objectKey).UploadStream(ctx, &md5Reader{r: r, hash: md5.New(), headers: headers}, &blockblob.UploadStreamOptions{
+		BlockSize:               d.upload.partSize,
+		Concurrency:             d.upload.concurrency,
+		HTTPHeaders:             headers,
+		Metadata:                azureMetadata(metadata),
+		TransactionalValidation: blob.TransferValidationTypeComputeCRC64(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload stream to container %q with key %q: %w", d.container, objectKey, err)
+	}
+	return nil
+}
+
+// md5Reader hashes a stream as it is read and, once it ends, sets its MD5 in
+// the headers the blob is committed with.
+type md5Reader struct {
+	r       io.Reader
+	hash    hash.Hash
+	headers *blob.HTTPHeaders
+}
+
+func (m *md5Reader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	m.hash.Write(p[:n])
+	if err == io.EOF {
+		m.headers.BlobContentMD5 = m.hash.Sum(nil)
+	}
+	return n, err
+}
+
+// UploadFile uploads a local file as a block blob, staging its blocks in
+// parallel, each with a CRC64 that the service checks on receipt. The blob
+// records the MD5 of digest, which Stat reports for the upload to be checked.
+func (d *AzureBlobDestination) UploadFile(ctx context.Context, filePath, objectKey string, digest Digest) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file %q: %w", filePath, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file %q: %w", filePath, err)
+	}
+
+	headers := &blob.HTTPHeaders{}
+	if sum, err := hex.DecodeString(digest.MD5); err == nil && len(sum) > 0 {
+		headers.BlobContentMD5 = sum
+	}
+	_, err = d.client.NewBlockBlobClient(objectKey).UploadFile(ctx, file, &blockblob.UploadFileOptions{
+		BlockSize:               d.upload.partSizeFor(info.Size()),
+		Concurrency:             uint16(d.upload.concurrency),
+		HTTPHeaders:             headers,
+		TransactionalValidation: blob.TransferValidationTypeComputeCRC64(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload file %q to container %q with key %q: %w", filePath, d.container, objectKey, err)
+	}
+	return nil
+}
+
+// List returns every blob in the container whose name starts with prefix.
+func (d *AzureBlobDestination) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	pager := d.client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{Prefix: &prefix})
+
+	var objects []ObjectInfo
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list blobs in container %q with prefix %q: %w", d.container, prefix, err)
+		}
+		for _, item := range page.Segment.BlobItems {
+			object := ObjectInfo{Key: deref(item.Name)}
+			if item.Properties != nil {
+				object.Size = deref(item.Properties.ContentLength)
+				object.LastModified = deref(item.Properties.LastModified)
+			}
+			objects = append(objects, object)
+		}
+	}
+	return objects, nil
+}
+
+// Download opens a blob for reading.
+func (d *AzureBlobDestination) Download(ctx context.Context, objectKey string) (io.ReadCloser, error) {
+	resp, err := d.client.NewBlobClient(objectKey).DownloadStream(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download blob %q from container %q: %w", objectKey, d.container, azureError(err))
+	}
+	return resp.Body, nil
+}
+
+// Delete removes a blob.
+func (d *AzureBlobDestination) Delete(ctx context.Context, objectKey string) error {
+	if _, err := d.client.NewBlobClient(objectKey).Delete(ctx, nil); err != nil {
+		return fmt.Errorf("failed to delete blob %q from container %q: %w", objectKey, d.container, azureError(err))
+	}
+	return nil
+}
+
+// Stat describes a blob, including its metadata and the MD5 recorded when it
+// was uploaded. Azure does not preserve the case of metadata keys, so they
+// are returned lower-cased.
+func (d *AzureBlobDestination) Stat(ctx context.Context, objectKey string) (ObjectInfo, error) {
+	props, err := d.client.NewBlobClient(objectKey).GetProperties(ctx, nil)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to stat blob %q in container %q: %w", objectKey, d.container, azureError(err))
+	}
+
+	object := ObjectInfo{
+		Key:          objectKey,
+		Size:         deref(props.ContentLength),
+		LastModified: deref(props.LastModified),
+		Checksums:    Checksums{MD5: hex.EncodeToString(props.ContentMD5)},
+	}
+	if len(props.Metadata) > 0 {
+		object.Metadata = make(map[string]string, len(props.Metadata))
+		for key, value := range props.Metadata {
+			object.Metadata[strings.ToLower(key)] = deref(value)
+		}
+	}
+	return object, nil
+}
+
+// azureMetadata converts object metadata to the form the Azure SDK expects.
+func azureMetadata(metadata map[string]string) map[string]*string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	converted := make(map[string]*string, len(metadata))
+	for key, value := range metadata {
+		converted[key] = &value
+	}
+	return converted
+}
+
+// azureError marks errors for missing blobs with ErrNotFound.
+func azureError(err error) error {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return err
+}
+
+// deref returns the value p points to, or the zero value if p is nil.
+func deref[T any](p *T) T {
+	var zero T
+	if p == nil {
+		return zero
+	}
+	return *p
+}
@@ -0,0 +1,372 @@
+package remotestorage
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+// azureBlob is a blob stored by the test Blob Storage server.
+type azureBlob struct {
+	data     []byte
+	metadata http.Header
+	modified time.Time
+	// md5 is the base64-encoded MD5 set when the blob was written.
+	md5 string
+}
+
+// azureServer implements the subset of the Blob Storage REST API used by
+// AzureBlobDestination for a single container, "backups", of the account
+// "devstoreaccount1".
+type azureServer struct {
+	mu     sync.Mutex
+	blobs  map[string]*azureBlob
+	staged map[string]map[string][]byte
+	// arrivals are the IDs of blocks in the order they were staged, and
+	// commits the block lists committed.
+	arrivals []string
+	commits  [][]string
+	// delayed is set once a block has been delayed.
+	delayed bool
+}
+
+const azureContainerPath = "/devstoreaccount1/backups"
+
+func (s *azureServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if r.URL.Path == azureContainerPath {
+		switch {
+		case query.Get("comp") == "list":
+			s.list(w, query.Get("prefix"))
+		case query.Get("restype") == "container":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		return
+	}
+
+	name, ok := strings.CutPrefix(r.URL.Path, azureContainerPath+"/")
+	if !ok {
+		azureErrorResponse(w, http.StatusNotFound, "ContainerNotFound")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPut && query.Get("comp") == "block":
+		s.stageBlock(w, r, name, query.Get("blockid"))
+	case r.Method == http.MethodPut && query.Get("comp") == "blocklist":
+		s.commitBlockList(w, r, name)
+	case r.Method == http.MethodPut && query.Get("comp") == "":
+		s.putBlob(w, r, name)
+	case r.Method == http.MethodHead, r.Method == http.MethodGet, r.Method == http.MethodDelete:
+		s.mu.Lock()
+		blob := s.blobs[name]
+		if blob != nil && r.Method == http.MethodDelete {
+			delete(s.blobs, name)
+		}
+		s.mu.Unlock()
+		if blob == nil {
+			azureErrorResponse(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		for key, values := range blob.metadata {
+			w.Header()[key] = values
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(blob.data)))
+		w.Header().Set("Last-Modified", blob.modified.Format(http.TimeFormat))
+		w.Header().Set("x-ms-blob-type", "BlockBlob")
+		if blob.md5 != "" {
+			w.Header().Set("Content-MD5", blob.md5)
+		}
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(blob.data)
+		}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// stageBlock stores an uncommitted block. The first block staged is delayed
+// so that later blocks are staged before it.
+func (s *azureServer) stageBlock(w http.ResponseWriter, r *http.Request, name, blockID string) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	delay := !s.delayed
+	s.delayed = true
+	s.mu.Unlock()
+	if delay {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.staged[name] == nil {
+		s.staged[name] = make(map[string][]byte)
+	}
+	s.staged[name][blockID] = data
+	s.arrivals = append(s.arrivals, blockID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// putBlob writes a blob uploaded in a single request.
+func (s *azureServer) putBlob(w http.ResponseWriter, r *http.Request, name string) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	blob := &azureBlob{data: data, metadata: blobMetadata(r.Header), modified: time.Now().UTC(), md5: r.Header.Get("x-ms-blob-content-md5")}
+	s.blobs[name] = blob
+	w.Header().Set("Last-Modified", blob.modified.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// commitBlockList writes a blob from the staged blocks it lists.
+func (s *azureServer) commitBlockList(w http.ResponseWriter, r *http.Request, name string) {
+	var list struct {
+		Latest []string `xml:"Latest"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&list); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	blob := &azureBlob{metadata: blobMetadata(r.Header), modified: time.Now().UTC(), md5: r.Header.Get("x-ms-blob-content-md5")}
+	for _, id := range list.Latest {
+		data, ok := s.staged[name][id]
+		if !ok {
+			azureErrorResponse(w, http.StatusBadRequest, "InvalidBlockList")
+			return
+		}
+		blob.data = append(blob.data, data...)
+	}
+	delete(s.staged, name)
+	s.blobs[name] = blob
+	s.commits = append(s.commits, list.Latest)
+	w.Header().Set("Last-Modified", blob.modified.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// list writes the blobs whose names start with prefix.
+func (s *azureServer) list(w http.ResponseWriter, prefix string) {
+	s.mu.Lock()
+	var names []string
+	for name := range s.blobs {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="backups"><Blobs>`)
+	for _, name := range names {
+		blob := s.blobs[name]
+		fmt.Fprintf(&b, "<Blob><Name>%s</Name><Properties><Last-Modified>%s</Last-Modified><Content-Length>%d</Content-Length><BlobType>BlockBlob</BlobType></Properties></Blob>",
+			name, blob.modified.Format(http.TimeFormat), len(blob.data))
+	}
+	b.WriteString(`</Blobs><NextMarker/></EnumerationResults>`)
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, b.String())
+}
+
+// blobMetadata returns the metadata headers of a request.
+func blobMetadata(header http.Header) http.Header {
+	metadata := make(http.Header)
+	for key, values := range header {
+		if strings.HasPrefix(strings.ToLower(key), "x-ms-meta-") {
+			metadata[key] = values
+		}
+	}
+	return metadata
+}
+
+func azureErrorResponse(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("x-ms-error-code", code)
+	w.WriteHeader(status)
+}
+
+// newTestAzureBlobDestination returns a destination for the container of a
+// new test Blob Storage server, with the smallest block size the SDK allows.
+func newTestAzureBlobDestination(t *testing.T) (*azureServer, *AzureBlobDestination) {
+	t.Helper()
+	fake := &azureServer{blobs: make(map[string]*azureBlob), staged: make(map[string]map[string][]byte)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	dest, err := NewAzureBlobDestination(models.DestinationConfig{
+		Provider:    "azureblob",
+		AccountName: "devstoreaccount1",
+		Container:   "backups",
+		SASToken:    "sv=2022-11-02&sig=test",
+		EndpointURL: server.URL + "/devstoreaccount1",
+		Upload:      models.UploadConfig{Concurrency: 4, MaxRetries: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest.upload.partSize = mib
+	return fake, dest
+}
+
+// testBlobData returns data that is uploaded as 5 blocks.
+func testBlobData() string {
+	content := make([]byte, 4*mib+100)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	return string(content)
+}
+
+func TestAzureBlobDestination(t *testing.T) {
+	ctx := context.Background()
+	fake, dest := newTestAzureBlobDestination(t)
+	if err := dest.Validate(ctx); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	// The stream is staged as several blocks, some of which are staged out
+	// of order.
+	data := testBlobData()
+	if err := dest.Upload(ctx, strings.NewReader(data), "host/backup.tar.gz", map[string]string{"sha256": "abc"}); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if err := dest.Upload(ctx, strings.NewReader("other"), "other.tar.gz", nil); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if len(fake.commits) != 1 || len(fake.commits[0]) != 5 {
+		t.Fatalf("committed block lists = %v, want one of 5 blocks", fake.commits)
+	}
+	if strings.Join(fake.arrivals, ",") == strings.Join(fake.commits[0], ",") {
+		t.Error("blocks were staged in order, so their commit order is untested")
+	}
+	if got := string(fake.blobs["host/backup.tar.gz"].data); got != data {
+		t.Error("committed blob differs from the uploaded stream")
+	}
+
+	objects, err := dest.List(ctx, "")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	var keys []string
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+	if strings.Join(keys, ",") != "host/backup.tar.gz,other.tar.gz" {
+		t.Errorf("List() keys = %v, want host/backup.tar.gz and other.tar.gz", keys)
+	}
+	if objects, err := dest.List(ctx, "host/"); err != nil || len(objects) != 1 || objects[0].Size != int64(len(data)) || objects[0].LastModified.IsZero() {
+		t.Errorf("List(host/) = %+v, %v, want host/backup.tar.gz of %d bytes", objects, err, len(data))
+	}
+
+	body, err := dest.Download(ctx, "host/backup.tar.gz")
+	if err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	got, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || string(got) != data {
+		t.Errorf("Download() returned %d bytes, %v, want the %d bytes uploaded", len(got), err, len(data))
+	}
+
+	info, err := dest.Stat(ctx, "host/backup.tar.gz")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Size != int64(len(data)) || info.Metadata["sha256"] != "abc" {
+		t.Errorf("Stat() = %+v, want size %d and the uploaded metadata", info, len(data))
+	}
+	if want := digestOf(data).MD5; info.Checksums.MD5 != want {
+		t.Errorf("Stat() MD5 = %q, want that of the stream, %q", info.Checksums.MD5, want)
+	}
+
+	if err := dest.Delete(ctx, "host/backup.tar.gz"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, ok := fake.blobs["host/backup.tar.gz"]; ok {
+		t.Error("blob still exists after Delete()")
+	}
+
+	// Missing blobs are reported as ErrNotFound
+	if _, err := dest.Stat(ctx, "host/backup.tar.gz"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat() of a deleted blob error = %v, want ErrNotFound", err)
+	}
+	if _, err := dest.Download(ctx, "host/backup.tar.gz"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Download() of a deleted blob error = %v, want ErrNotFound", err)
+	}
+	if err := dest.Delete(ctx, "host/backup.tar.gz"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete() of a deleted blob error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestAzureBlobUploadFile(t *testing.T) {
+	ctx := context.Background()
+	data := testBlobData()
+	archive := filepath.Join(t.TempDir(), "backup.tar.gz")
+	if err := os.WriteFile(archive, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		digest Digest
+	}{
+		{"with digest", digestOf(data)},
+		{"without checksums", Digest{Size: int64(len(data))}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, dest := newTestAzureBlobDestination(t)
+			if err := UploadFile(ctx, dest, archive, "backup.tar.gz", tt.digest); err != nil {
+				t.Fatalf("UploadFile() error = %v", err)
+			}
+			// The SDK sends files of up to 256 MiB in a single request, and
+			// larger ones in blocks committed with the same headers. Either
+			// way, the blob records the MD5 of the digest, which the upload
+			// was verified against.
+			if got := fake.blobs["backup.tar.gz"].md5; got != hexToBase64(tt.digest.MD5) {
+				t.Errorf("recorded MD5 = %q, want %q", got, hexToBase64(tt.digest.MD5))
+			}
+			info, err := dest.Stat(ctx, "backup.tar.gz")
+			if err != nil {
+				t.Fatalf("Stat() error = %v", err)
+			}
+			if info.Checksums.MD5 != tt.digest.MD5 {
+				t.Errorf("Stat() MD5 = %q, want %q", info.Checksums.MD5, tt.digest.MD5)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package remotestorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+// Destination is a remote storage backend that archives are uploaded to.
+// Each provider implements it and registers itself with Register.
+type Destination interface {
+	// Validate checks that the destination is reachable and usable.
+	Validate(ctx context.Context) error
+	// Upload stores everything read from r as objectKey, with the given metadata.
+	Upload(ctx context.Context, r io.Reader, objectKey string, metadata map[string]string) error
+	// List returns every object whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// Download opens an object for reading. The caller must close it.
+	Download(ctx context.Context, objectKey string) (io.ReadCloser, error)
+	// Delete removes an object.
+	Delete(ctx context.Context, objectKey string) error
+	// Stat describes a single object.
+	Stat(ctx context.Context, objectKey string) (ObjectInfo, error)
+}
+
+// FileUploader is implemented by destinations that upload local files more
+// efficiently than a stream, e.g. in parallel or resumably. The digest of the
+// file is passed along for destinations that send checksums with the upload.
+type FileUploader interface {
+	UploadFile(ctx context.Context, filePath, objectKey string, digest Digest) error
+}
+
+// StaleUploadAborter is implemented by destinations that can be left with
+// incomplete uploads by crashed runs.
+type StaleUploadAborter interface {
+	AbortStaleUploads(ctx context.Context, prefix string) error
+}
+
+// ObjectInfo describes an object stored on a destination.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	// Metadata is only filled in by Stat.
+	Metadata map[string]string
+	// Checksums is only filled in by Stat, with the digests the provider
+	// keeps for the object, if any.
+	Checksums Checksums
+}
+
+// ErrNotFound is returned by Stat and Download for objects that do not exist.
+var ErrNotFound = errors.New("object not found")
+
+// Provider creates destinations of one kind.
+type Provider struct {
+	// New creates a destination from its config.
+	New func(ctx context.Context, cfg models.DestinationConfig) (Destination, error)
+	// CheckConfig reports missing or invalid provider-specific settings when
+	// the config is loaded. It may be nil.
+	CheckConfig func(cfg models.DestinationConfig) error
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[string]Provider)
+)
+
+// Register makes a provider available under the given name, as used in the
+// provider field of a destination. It panics if the name is already taken.
+func Register(name string, provider Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	if _, dup := providers[name]; dup {
+		panic("remotestorage: Register called twice for provider " + name)
+	}
+	providers[name] = provider
+}
+
+// Providers returns the names of the registered providers, sorted.
+func Providers() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupProvider(name string) (Provider, error) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	provider, ok := providers[name]
+	if !ok {
+		return Provider{}, fmt.Errorf("unknown destination provider %q", name)
+	}
+	return provider, nil
+}
+
+// New creates the destination described by cfg using its provider.
+func New(ctx context.Context, cfg models.DestinationConfig) (Destination, error) {
+	provider, err := lookupProvider(cfg.Provider)
+	if err != nil {
+		return nil, err
+	}
+	return provider.New(ctx, cfg)
+}
+
+// CheckConfig validates the provider-specific settings of a destination.
+func CheckConfig(cfg models.DestinationConfig) error {
+	provider, err := lookupProvider(cfg.Provider)
+	if err != nil {
+		return err
+	}
+	if provider.CheckConfig == nil {
+		return nil
+	}
+	return provider.CheckConfig(cfg)
+}
+
+// UploadFile uploads a local file to the destination, using its own file
+// upload when it has one, then checks that the stored object matches digest,
+// which was computed as the file was written. The file is therefore read only
+// once, and any change to it since it was written fails the upload.
+func UploadFile(ctx context.Context, dest Destination, filePath, objectKey string, digest Digest) error {
+	if uploader, ok := dest.(FileUploader); ok {
+		if err := uploader.UploadFile(ctx, filePath, objectKey, digest); err != nil {
+			return err
+		}
+		return verifyUpload(ctx, dest, objectKey, digest)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file %q: %w", filePath, err)
+	}
+	defer file.Close()
+
+	if err := dest.Upload(ctx, file, objectKey, nil); err != nil {
+		return err
+	}
+	return verifyUpload(ctx, dest, objectKey, digest)
+}
+
+// DownloadFile downloads an object from the destination to filePath.
+func DownloadFile(ctx context.Context, dest Destination, objectKey, filePath string) error {
+	body, err := dest.Download(ctx, objectKey)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create file %q: %w", filePath, err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, body); err != nil {
+		return fmt.Errorf("failed to write object %q to %q: %w", objectKey, filePath, err)
+	}
+	return file.Close()
+}
+
+// splitPrefix splits a key prefix into the directory of the objects it
+// selects, with its trailing slash, and the start of their names.
+func splitPrefix(prefix string) (dir, name string) {
+	i := strings.LastIndex(prefix, "/")
+	return prefix[:i+1], prefix[i+1:]
+}
@@ -0,0 +1,388 @@
+package remotestorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tderick/backup-companion-go/internal/models"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	defaultGCSEndpoint = "https://storage.googleapis.com"
+	gcsScope           = "https://www.googleapis.com/auth/devstorage.read_write"
+)
+
+func init() {
+	Register("gcs", Provider{
+		New: func(ctx context.Context, cfg models.DestinationConfig) (Destination, error) {
+			return NewGCSDestination(cfg)
+		},
+		CheckConfig: func(cfg models.DestinationConfig) error {
+			if cfg.BucketName == "" {
+				return errors.New("missing bucketName")
+			}
+			return nil
+		},
+	})
+}
+
+// GCSDestination stores objects in a Google Cloud Storage bucket through the
+// JSON API, authenticated as a service account.
+type GCSDestination struct {
+	client     *http.Client
+	bucketName string
+	apiURL     string
+	uploadURL  string
+	upload     uploadOptions
+}
+
+// NewGCSDestination creates a client for the bucket from the service account
+// key in the config. EndpointURL replaces the public endpoint, e.g. for an
+// emulator.
+func NewGCSDestination(cfg models.DestinationConfig) (*GCSDestination, error) {
+	jwtConfig, err := google.JWTConfigFromJSON([]byte(cfg.Credentials), gcsScope)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service account credentials: %w", err)
+	}
+
+	endpoint := strings.TrimSuffix(cfg.EndpointURL, "/")
+	if endpoint == "" {
+		endpoint = defaultGCSEndpoint
+	}
+	bucket := url.PathEscape(cfg.BucketName)
+
+	return &GCSDestination{
+		client:     jwtConfig.Client(context.Background()),
+		bucketName: cfg.BucketName,
+		apiURL:     endpoint + "/storage/v1/b/" + bucket,
+		uploadURL:  endpoint + "/upload/storage/v1/b/" + bucket + "/o?uploadType=resumable",
+		upload:     newUploadOptions(cfg.Upload),
+	}, nil
+}
+
+// gcsStatusError is an unexpected HTTP response from Cloud Storage.
+type gcsStatusError struct {
+	status  int
+	message string
+}
+
+func (e *gcsStatusError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.status, http.StatusText(e.status), e.message)
+}
+
+// gcsRetryable reports whether a failed request may succeed when retried.
+func gcsRetryable(err error) bool {
+	var statusErr *gcsStatusError
+	if !errors.As(err, &statusErr) {
+		return true // Network errors
+	}
+	return statusErr.status == http.StatusTooManyRequests || statusErr.status >= 500
+}
+
+// do sends a request and fails unless the response has one of the expected
+// status codes. The caller must close the body of the returned response.
+func (d *GCSDestination) do(ctx context.Context, method, rawURL string, body io.Reader, header http.Header, expected ...int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = &gcsStatusError{status: resp.StatusCode, message: strings.TrimSpace(string(message))}
+	if resp.StatusCode == http.StatusNotFound {
+		err = fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return nil, err
+}
+
+// objectURL returns the API URL of an object.
+func (d *GCSDestination) objectURL(objectKey string) string {
+	return d.apiURL + "/o/" + url.PathEscape(objectKey)
+}
+
+// Validate checks that the bucket exists and is accessible.
+func (d *GCSDestination) Validate(ctx context.Context) error {
+	resp, err := d.do(ctx, http.MethodGet, d.apiURL+"?fields=name", nil, nil, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("failed to validate GCS connection for bucket %q: %w", d.bucketName, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Upload uploads everything read from r as objectKey through a resumable
+// upload session. The stream is sent one part at a time; a part that fails
+// is retried from the last byte the server confirmed. The last part carries
+// the CRC32C and MD5 of the whole stream, which Cloud Storage checks before
+// creating the object.
+func (d *GCSDestination) Upload(ctx context.Context, r io.Reader, objectKey string, metadata map[string]string) error {
+	session, err := d.startResumableUpload(ctx, objectKey, metadata)
+	if err != nil {
+		return err
+	}
+
+	sum := NewChecksummer()
+	r = io.TeeReader(r, sum)
+
+	buf := make([]byte, d.upload.partSize)
+	var offset int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			d.cancelResumableUpload(session)
+			return fmt.Errorf("failed to read upload stream for key %q: %w", objectKey, err)
+		}
+
+		total := int64(-1) // Unknown until the end of the stream
+		hashes := ""
+		if last {
+			total = offset + int64(n)
+			hashes = gcsHashes(sum.Checksums())
+		}
+		if err := d.sendChunk(ctx, session, objectKey, buf[:n], offset, total, hashes); err != nil {
+			d.cancelResumableUpload(session)
+			return err
+		}
+		if last {
+			return nil
+		}
+		offset += int64(n)
+	}
+}
+
+// startResumableUpload creates an upload session and returns its URL.
+func (d *GCSDestination) startResumableUpload(ctx context.Context, objectKey string, metadata map[string]string) (string, error) {
+	body, err := json.Marshal(map[string]any{"name": objectKey, "metadata": metadata})
+	if err != nil {
+		return "", err
+	}
+	header := http.Header{"Content-Type": {"application/json; charset=UTF-8"}}
+
+	resp, err := d.do(ctx, http.MethodPost, d.uploadURL, bytes.NewReader(body), header, http.StatusOK)
+	if err != nil {
+		return "", fmt.Errorf("failed to start upload to bucket %q with key %q: %w", d.bucketName, objectKey, err)
+	}
+	resp.Body.Close()
+
+	session := resp.Header.Get("Location")
+	if session == "" {
+		return "", fmt.Errorf("failed to start upload to bucket %q with key %q: no session URL returned", d.bucketName, objectKey)
+	}
+	return session, nil
+}
+
+// sendChunk uploads the bytes of the object starting at offset, retrying
+// with backoff. total is the object size when chunk is the last one, or -1;
+// the last chunk also carries the hashes of the object.
+func (d *GCSDestination) sendChunk(ctx context.Context, session, objectKey string, chunk []byte, offset, total int64, hashes string) error {
+	end := offset + int64(len(chunk))
+	for attempt := 0; ; {
+		persisted, done, err := d.putChunk(ctx, session, chunk, offset, total, hashes)
+		if err == nil {
+			if done || persisted >= end {
+				return nil
+			}
+			if persisted < offset {
+				return fmt.Errorf("upload of key %q lost data: server has %d bytes, expected at least %d", objectKey, persisted, offset)
+			}
+			// The server kept only the beginning of the chunk; send the rest.
+			chunk, offset = chunk[persisted-offset:], persisted
+			continue
+		}
+		if !gcsRetryable(err) || attempt >= d.upload.maxRetries || ctx.Err() != nil {
+			return fmt.Errorf("failed to upload bytes %d-%d of key %q after %d attempts: %w", offset, end, objectKey, attempt+1, err)
+		}
+
+		delay := retryDelay(attempt)
+		slog.Warn("Retrying chunk upload", "key", objectKey, "offset", offset, "attempt", attempt+1, "delay", delay, "error", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		attempt++
+
+		// Ask how much of the chunk arrived before the failure.
+		persisted, done, err = d.putChunk(ctx, session, nil, 0, -1, "")
+		if err != nil {
+			continue // Resend the whole chunk
+		}
+		if done {
+			return nil
+		}
+		if persisted > offset && persisted <= end {
+			chunk, offset = chunk[persisted-offset:], persisted
+		}
+	}
+}
+
+// putChunk sends chunk as the bytes of the object starting at offset, or
+// queries the upload status if chunk and total are empty. hashes, when set,
+// is sent as the X-Goog-Hash header. It returns how many bytes the server
+// has persisted and whether the upload is complete.
+func (d *GCSDestination) putChunk(ctx context.Context, session string, chunk []byte, offset, total int64, hashes string) (int64, bool, error) {
+	size := "*"
+	if total >= 0 {
+		size = strconv.FormatInt(total, 10)
+	}
+	contentRange := "bytes */" + size
+	if len(chunk) > 0 {
+		contentRange = fmt.Sprintf("bytes %d-%d/%s", offset, offset+int64(len(chunk))-1, size)
+	}
+	header := http.Header{"Content-Range": {contentRange}}
+	if hashes != "" {
+		header.Set("X-Goog-Hash", hashes)
+	}
+
+	// 308 Permanent Redirect is how Cloud Storage reports an incomplete upload.
+	resp, err := d.do(ctx, http.MethodPut, session, bytes.NewReader(chunk), header, http.StatusOK, http.StatusCreated, http.StatusPermanentRedirect)
+	if err != nil {
+		return 0, false, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPermanentRedirect {
+		return total, true, nil
+	}
+
+	// The Range header, when present, is "bytes=0-<last persisted byte>".
+	persisted := int64(0)
+	if last, ok := strings.CutPrefix(resp.Header.Get("Range"), "bytes=0-"); ok {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid Range header %q in upload status", resp.Header.Get("Range"))
+		}
+		persisted = n + 1
+	}
+	return persisted, false, nil
+}
+
+// gcsHashes formats checksums as an X-Goog-Hash header value.
+func gcsHashes(sums Checksums) string {
+	crc, _ := hex.DecodeString(sums.CRC32C)
+	md5, _ := hex.DecodeString(sums.MD5)
+	return "crc32c=" + base64.StdEncoding.EncodeToString(crc) + ",md5=" + base64.StdEncoding.EncodeToString(md5)
+}
+
+// cancelResumableUpload discards an upload session and the data sent to it.
+func (d *GCSDestination) cancelResumableUpload(session string) {
+	// 499 is how Cloud Storage acknowledges a cancelled upload.
+	resp, err := d.do(context.Background(), http.MethodDelete, session, nil, nil, 499, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		slog.Warn("Failed to cancel resumable upload", "bucket", d.bucketName, "error", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// gcsObject is the subset of an object resource that is used.
+type gcsObject struct {
+	Name     string            `json:"name"`
+	Size     string            `json:"size"`
+	Updated  time.Time         `json:"updated"`
+	Metadata map[string]string `json:"metadata"`
+	MD5Hash  string            `json:"md5Hash"`
+	CRC32C   string            `json:"crc32c"`
+}
+
+func (o gcsObject) info() ObjectInfo {
+	size, _ := strconv.ParseInt(o.Size, 10, 64)
+	return ObjectInfo{Key: o.Name, Size: size, LastModified: o.Updated}
+}
+
+// List returns every object in the bucket whose name starts with prefix.
+func (d *GCSDestination) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	query := url.Values{
+		"prefix": {prefix},
+		"fields": {"items(name,size,updated),nextPageToken"},
+	}
+	for {
+		resp, err := d.do(ctx, http.MethodGet, d.apiURL+"/o?"+query.Encode(), nil, nil, http.StatusOK)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects in bucket %q with prefix %q: %w", d.bucketName, prefix, err)
+		}
+
+		var page struct {
+			Items         []gcsObject `json:"items"`
+			NextPageToken string      `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse object listing of bucket %q: %w", d.bucketName, err)
+		}
+
+		for _, item := range page.Items {
+			objects = append(objects, item.info())
+		}
+		if page.NextPageToken == "" {
+			return objects, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+// Download opens an object in the bucket for reading.
+func (d *GCSDestination) Download(ctx context.Context, objectKey string) (io.ReadCloser, error) {
+	resp, err := d.do(ctx, http.MethodGet, d.objectURL(objectKey)+"?alt=media", nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download object %q from bucket %q: %w", objectKey, d.bucketName, err)
+	}
+	return resp.Body, nil
+}
+
+// Delete removes an object from the bucket.
+func (d *GCSDestination) Delete(ctx context.Context, objectKey string) error {
+	resp, err := d.do(ctx, http.MethodDelete, d.objectURL(objectKey), nil, nil, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("failed to delete object %q from bucket %q: %w", objectKey, d.bucketName, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Stat describes an object in the bucket, including its metadata and hashes.
+func (d *GCSDestination) Stat(ctx context.Context, objectKey string) (ObjectInfo, error) {
+	resp, err := d.do(ctx, http.MethodGet, d.objectURL(objectKey)+"?fields=name,size,updated,metadata,md5Hash,crc32c", nil, nil, http.StatusOK)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to stat object %q in bucket %q: %w", objectKey, d.bucketName, err)
+	}
+	defer resp.Body.Close()
+
+	var object gcsObject
+	if err := json.NewDecoder(resp.Body).Decode(&object); err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to parse metadata of object %q: %w", objectKey, err)
+	}
+	info := object.info()
+	info.Metadata = object.Metadata
+	info.Checksums = Checksums{MD5: base64ToHex(object.MD5Hash), CRC32C: base64ToHex(object.CRC32C)}
+	return info, nil
+}
@@ -0,0 +1,130 @@
+package remotestorage
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log/slog"
+)
+
+// Checksums are hex-encoded digests of the content of an object. Empty
+// fields are unknown.
+type Checksums struct {
+	SHA256 string `json:"sha256,omitempty"`
+	MD5    string `json:"md5,omitempty"`
+	CRC32C string `json:"crc32c,omitempty"`
+}
+
+// Digest is the size and checksums of some content, against which an
+// uploaded copy is verified.
+type Digest struct {
+	Size int64 `json:"size"`
+	Checksums
+}
+
+// ErrIntegrity is returned when an uploaded object does not match what was sent.
+var ErrIntegrity = errors.New("uploaded object does not match the local data")
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Checksummer computes the size and checksums of everything written to it.
+type Checksummer struct {
+	size   int64
+	sha256 hash.Hash
+	md5    hash.Hash
+	crc32c hash.Hash32
+}
+
+func NewChecksummer() *Checksummer {
+	return &Checksummer{sha256: sha256.New(), md5: md5.New(), crc32c: crc32.New(crc32cTable)}
+}
+
+func (c *Checksummer) Write(p []byte) (int, error) {
+	c.sha256.Write(p)
+	c.md5.Write(p)
+	c.crc32c.Write(p)
+	c.size += int64(len(p))
+	return len(p), nil
+}
+
+// Checksums returns the checksums of what was written so far.
+func (c *Checksummer) Checksums() Checksums {
+	return Checksums{
+		SHA256: hex.EncodeToString(c.sha256.Sum(nil)),
+		MD5:    hex.EncodeToString(c.md5.Sum(nil)),
+		CRC32C: hex.EncodeToString(c.crc32c.Sum(nil)),
+	}
+}
+
+// Digest returns the size and checksums of what was written so far.
+func (c *Checksummer) Digest() Digest {
+	return Digest{Size: c.size, Checksums: c.Checksums()}
+}
+
+// uploadVerified uploads everything read from r like dest.Upload, then checks
+// the stored object against what was read; see verifyUpload.
+func uploadVerified(ctx context.Context, dest Destination, r io.Reader, objectKey string, metadata map[string]string) error {
+	sum := NewChecksummer()
+	if err := dest.Upload(ctx, io.TeeReader(r, sum), objectKey, metadata); err != nil {
+		return err
+	}
+	return verifyUpload(ctx, dest, objectKey, sum.Digest())
+}
+
+// verifyUpload checks that the object stored as objectKey has the size and
+// checksums of want. Destinations that keep no checksum of their objects are
+// only checked for size.
+func verifyUpload(ctx context.Context, dest Destination, objectKey string, want Digest) error {
+	info, err := dest.Stat(ctx, objectKey)
+	if err != nil {
+		return fmt.Errorf("failed to check uploaded object %q: %w", objectKey, err)
+	}
+	if info.Size != want.Size {
+		return fmt.Errorf("%w: object %q has %d bytes, %d were sent", ErrIntegrity, objectKey, info.Size, want.Size)
+	}
+
+	local := want.Checksums
+	compared := 0
+	for _, c := range []struct{ name, remote, local string }{
+		{"SHA-256", info.Checksums.SHA256, local.SHA256},
+		{"MD5", info.Checksums.MD5, local.MD5},
+		{"CRC32C", info.Checksums.CRC32C, local.CRC32C},
+	} {
+		if c.remote == "" {
+			continue
+		}
+		if c.remote != c.local {
+			return fmt.Errorf("%w: %s of object %q is %s, expected %s", ErrIntegrity, c.name, objectKey, c.remote, c.local)
+		}
+		compared++
+	}
+	slog.Debug("Verified uploaded object", "key", objectKey, "size", info.Size, "checksums_compared", compared)
+	return nil
+}
+
+// base64ToHex converts a base64-encoded digest, as returned by most storage
+// APIs, to hex. Invalid input gives an empty string, i.e. an unknown digest.
+func base64ToHex(s string) string {
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(raw) == 0 {
+		return ""
+	}
+	return hex.EncodeToString(raw)
+}
+
+// hexToBase64 converts a hex-encoded digest to the base64 encoding most
+// storage APIs expect. Invalid input gives an empty string.
+func hexToBase64(s string) string {
+	raw, err := hex.DecodeString(s)
+	if err != nil || len(raw) == 0 {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
@@ -0,0 +1,36 @@
+package remotestorage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+func TestUploadFileVerifiesDigest(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "backup.tar.gz")
+	if err := os.WriteFile(archive, []byte("archive contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dest := NewLocalDestination(models.DestinationConfig{Provider: "local", Path: t.TempDir()})
+
+	tests := []struct {
+		name    string
+		digest  Digest
+		wantErr error
+	}{
+		{"matching digest", digestOf("archive contents"), nil},
+		{"archive changed size since written", digestOf("archive"), ErrIntegrity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := UploadFile(context.Background(), dest, archive, "backup.tar.gz", tt.digest)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("UploadFile() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package remotestorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+const (
+	// tempPrefix starts the name of files still being written to a local
+	// destination. They are renamed into place once complete.
+	tempPrefix = ".tmp-"
+	// metadataExtension ends the name of the file holding an object's metadata.
+	metadataExtension = ".metadata.json"
+)
+
+func init() {
+	Register("local", Provider{
+		New: func(ctx context.Context, cfg models.DestinationConfig) (Destination, error) {
+			return NewLocalDestination(cfg), nil
+		},
+		CheckConfig: func(cfg models.DestinationConfig) error {
+			if cfg.Path == "" {
+				return errors.New("missing path")
+			}
+			return nil
+		},
+	})
+}
+
+// LocalDestination stores objects as files below a directory, typically a
+// mounted NAS or NFS share. Object keys map to paths relative to it.
+type LocalDestination struct {
+	root   string
+	upload uploadOptions
+}
+
+func NewLocalDestination(cfg models.DestinationConfig) *LocalDestination {
+	return &LocalDestination{
+		root:   filepath.Clean(cfg.Path),
+		upload: newUploadOptions(cfg.Upload),
+	}
+}
+
+// objectPath returns the file an object is stored in, rejecting keys that
+// would escape the destination directory.
+func (d *LocalDestination) objectPath(objectKey string) (string, error) {
+	name := path.Clean("/" + objectKey)
+	if name == "/" || strings.HasPrefix(path.Base(name), tempPrefix) || strings.HasSuffix(name, metadataExtension) {
+		return "", fmt.Errorf("invalid object key %q", objectKey)
+	}
+	return filepath.Join(d.root, filepath.FromSlash(name)), nil
+}
+
+// Validate checks that the destination directory exists and is writable.
+// The directory is not created, so that an unmounted share is noticed instead
+// of being filled on the local disk.
+func (d *LocalDestination) Validate(ctx context.Context) error {
+	info, err := os.Stat(d.root)
+	if err != nil {
+		return fmt.Errorf("failed to access directory %q: %w", d.root, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%q is not a directory", d.root)
+	}
+
+	file, err := os.CreateTemp(d.root, tempPrefix+"validate-")
+	if err != nil {
+		return fmt.Errorf("directory %q is not writable: %w", d.root, err)
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// Upload writes everything read from r to a temporary file, syncs it and
+// renames it into place, so that an object is either complete or absent.
+func (d *LocalDestination) Upload(ctx context.Context, r io.Reader, objectKey string, metadata map[string]string) error {
+	target, err := d.objectPath(objectKey)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %q: %w", target, err)
+	}
+
+	if len(metadata) > 0 {
+		data, err := json.Marshal(metadata)
+		if err != nil {
+			return fmt.Errorf("failed to encode metadata of %q: %w", objectKey, err)
+		}
+		if err := writeFileAtomic(target+metadataExtension, bytes.NewReader(data)); err != nil {
+			return err
+		}
+	}
+
+	if err := writeFileAtomic(target, contextReader{ctx: ctx, r: r}); err != nil {
+		return err
+	}
+	slog.Debug("Wrote object to local destination", "path", target)
+	return nil
+}
+
+// List returns every object whose key starts with prefix.
+func (d *LocalDestination) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := filepath.WalkDir(d.root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), tempPrefix) || strings.HasSuffix(entry.Name(), metadataExtension) {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(d.root, filePath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(relPath)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list directory %q: %w", d.root, err)
+	}
+	return objects, nil
+}
+
+// Download opens an object for reading.
+func (d *LocalDestination) Download(ctx context.Context, objectKey string) (io.ReadCloser, error) {
+	target, err := d.objectPath(objectKey)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(target)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+		return nil, fmt.Errorf("failed to open object %q: %w", objectKey, err)
+	}
+	return file, nil
+}
+
+// Delete removes an object and its metadata.
+func (d *LocalDestination) Delete(ctx context.Context, objectKey string) error {
+	target, err := d.objectPath(objectKey)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil {
+		return fmt.Errorf("failed to delete object %q: %w", objectKey, err)
+	}
+	if err := os.Remove(target + metadataExtension); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete metadata of object %q: %w", objectKey, err)
+	}
+	return nil
+}
+
+// Stat describes an object, including its metadata.
+func (d *LocalDestination) Stat(ctx context.Context, objectKey string) (ObjectInfo, error) {
+	target, err := d.objectPath(objectKey)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+		return ObjectInfo{}, fmt.Errorf("failed to stat object %q: %w", objectKey, err)
+	}
+
+	object := ObjectInfo{Key: objectKey, Size: info.Size(), LastModified: info.ModTime()}
+	data, err := os.ReadFile(target + metadataExtension)
+	if err == nil {
+		err = json.Unmarshal(data, &object.Metadata)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return ObjectInfo{}, fmt.Errorf("failed to read metadata of object %q: %w", objectKey, err)
+	}
+	return object, nil
+}
+
+// AbortStaleUploads removes the temporary files of objects starting with
+// prefix that were last written longer ago than the destination's
+// abortIncompleteAfter, such as those left behind by crashed runs.
+func (d *LocalDestination) AbortStaleUploads(ctx context.Context, prefix string) error {
+	cutoff := time.Now().Add(-d.upload.abortIncompleteAfter)
+
+	dirPrefix, namePrefix := splitPrefix(prefix)
+	dir := filepath.Join(d.root, filepath.FromSlash(dirPrefix))
+	pattern := filepath.Join(dir, globEscape(tempPrefix+namePrefix)+"*")
+	leftovers, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+
+	var removeErrors []error
+	for _, leftover := range leftovers {
+		info, err := os.Stat(leftover)
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		slog.Info("Removing stale incomplete upload", "path", leftover, "modified", info.ModTime())
+		if err := os.Remove(leftover); err != nil {
+			removeErrors = append(removeErrors, err)
+		}
+	}
+	return errors.Join(removeErrors...)
+}
+
+// writeFileAtomic writes the contents of r to target through a temporary file
+// in the same directory, so that target is replaced only once fully synced.
+func writeFileAtomic(target string, r io.Reader) (err error) {
+	dir, base := filepath.Split(target)
+	file, err := os.CreateTemp(dir, tempPrefix+base+"-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for %q: %w", target, err)
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+
+	if _, err = io.Copy(file, r); err != nil {
+		return fmt.Errorf("failed to write %q: %w", target, err)
+	}
+	if err = file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %q: %w", target, err)
+	}
+	if err = file.Close(); err != nil {
+		return fmt.Errorf("failed to close %q: %w", target, err)
+	}
+	if err = os.Chmod(file.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to set permissions of %q: %w", target, err)
+	}
+	if err = os.Rename(file.Name(), target); err != nil {
+		return fmt.Errorf("failed to move %q into place: %w", target, err)
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory %q: %w", dir, err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory %q: %w", dir, err)
+	}
+	return nil
+}
+
+// contextReader stops reading once its context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
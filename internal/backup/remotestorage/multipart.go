@@ -0,0 +1,627 @@
+package remotestorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/tderick/backup-companion-go/internal/backup/util"
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+const (
+	mib                         = 1024 * 1024
+	minPartSize                 = 5 * mib
+	maxParts                    = 10000
+	defaultPartSize             = 16 * mib
+	defaultConcurrency          = 4
+	defaultMaxRetries           = 5
+	defaultAbortIncompleteAfter = 24 * time.Hour
+	maxRetryDelay               = 30 * time.Second
+)
+
+// uploadOptions are the multipart settings of a destination, with defaults applied.
+type uploadOptions struct {
+	partSize             int64
+	concurrency          int
+	maxRetries           int
+	abortIncompleteAfter time.Duration
+	// maxParts is the most parts an upload may have.
+	maxParts int32
+}
+
+func newUploadOptions(cfg models.UploadConfig) uploadOptions {
+	opts := uploadOptions{
+		partSize:             int64(cfg.PartSizeMiB) * mib,
+		concurrency:          cfg.Concurrency,
+		maxRetries:           cfg.MaxRetries,
+		abortIncompleteAfter: cfg.AbortIncompleteAfter,
+		maxParts:             maxParts,
+	}
+	if opts.partSize == 0 {
+		opts.partSize = defaultPartSize
+	}
+	if opts.concurrency == 0 {
+		opts.concurrency = defaultConcurrency
+	}
+	if opts.maxRetries == 0 {
+		opts.maxRetries = defaultMaxRetries
+	}
+	if opts.abortIncompleteAfter == 0 {
+		opts.abortIncompleteAfter = defaultAbortIncompleteAfter
+	}
+	return opts
+}
+
+// partSizeFor returns the part size for a file of the given size, grown if
+// needed to stay within the S3 limit on the number of parts.
+func (o uploadOptions) partSizeFor(size int64) int64 {
+	partSize := o.partSize
+	for (size+partSize-1)/partSize > int64(o.maxParts) {
+		partSize *= 2
+	}
+	return partSize
+}
+
+// streamPartSize returns the size of the given part of a stream, whose size
+// is not known up front. The part size doubles every tenth of maxParts parts,
+// so that a stream of up to about 100 times maxParts parts of the configured
+// size, 16 TiB with the defaults, fits in maxParts parts.
+func (o uploadOptions) streamPartSize(number int32) int64 {
+	step := max(o.maxParts/10, 1)
+	return o.partSize << min((number-1)/step, 9)
+}
+
+// Upload uploads everything read from r as objectKey, with the given object
+// metadata. Streams larger than a single part are sent as a multipart upload
+// that holds at most one part per concurrent upload in memory, with parts
+// growing as the stream does (see streamPartSize). A stream cannot be
+// resumed, so a failed multipart upload is aborted.
+func (c *S3Client) Upload(ctx context.Context, r io.Reader, objectKey string, metadata map[string]string) error {
+	first := make([]byte, c.upload.partSize)
+	n, err := io.ReadFull(r, first)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// The whole stream fits in a single request.
+		body := bytes.NewReader(first[:n])
+		checksum, err := checksumSHA256(body)
+		if err != nil {
+			return fmt.Errorf("failed to compute checksum of upload stream for key %q: %w", objectKey, err)
+		}
+		_, err = c.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:         aws.String(c.bucketName),
+			Key:            aws.String(objectKey),
+			Body:           body,
+			Metadata:       metadata,
+			ChecksumSHA256: checksum,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to upload stream to bucket %q with key %q: %w", c.bucketName, objectKey, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read upload stream for key %q: %w", objectKey, err)
+	}
+
+	upload, err := c.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:            aws.String(c.bucketName),
+		Key:               aws.String(objectKey),
+		Metadata:          metadata,
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start multipart upload to bucket %q with key %q: %w", c.bucketName, objectKey, err)
+	}
+
+	number := int32(0)
+	next := func(buf *[]byte) (int32, io.ReadSeeker, error) {
+		if first != nil {
+			body := bytes.NewReader(first)
+			first = nil
+			number++
+			return number, body, nil
+		}
+		size := c.upload.streamPartSize(number + 1)
+		if int64(len(*buf)) < size {
+			*buf = make([]byte, size)
+		}
+		n, err := io.ReadFull(r, (*buf)[:size])
+		if n == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+			return 0, nil, io.EOF
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return 0, nil, fmt.Errorf("failed to read upload stream for key %q: %w", objectKey, err)
+		}
+		if number == c.upload.maxParts {
+			return 0, nil, fmt.Errorf("upload stream for key %q is larger than %d parts", objectKey, c.upload.maxParts)
+		}
+		number++
+		return number, bytes.NewReader((*buf)[:n]), nil
+	}
+
+	parts, err := c.uploadParts(ctx, objectKey, upload.UploadId, next)
+	if err == nil {
+		err = c.completeMultipartUpload(ctx, objectKey, upload.UploadId, parts)
+	}
+	if err != nil {
+		// Don't leave billable orphaned parts behind.
+		c.abortMultipartUpload(objectKey, upload.UploadId)
+		return err
+	}
+	return nil
+}
+
+// uploadState is persisted next to an archive while it is being uploaded, so
+// that a run interrupted mid-upload can be resumed by the next one.
+type uploadState struct {
+	Bucket      string    `json:"bucket"`
+	Endpoint    string    `json:"endpoint,omitempty"`
+	Key         string    `json:"key"`
+	UploadID    string    `json:"uploadId"`
+	ArchivePath string    `json:"archivePath"`
+	PartSize    int64     `json:"partSize"`
+	CreatedAt   time.Time `json:"createdAt"`
+
+	// Digest is that of the archive as it was written, which the upload is
+	// verified against when it is resumed by a later run.
+	Digest Digest `json:"digest"`
+}
+
+// stateExtension ends the name of every upload state file.
+const stateExtension = ".upload.json"
+
+// statePath returns where the upload state of filePath to this client's
+// bucket is kept. Each destination gets its own state file.
+func (c *S3Client) statePath(filePath string) string {
+	sum := sha256.Sum256([]byte(c.endpoint + "/" + c.bucketName))
+	return fmt.Sprintf("%s.%x%s", filePath, sum[:4], stateExtension)
+}
+
+// uploadFileMultipart uploads a file in parts, resuming the upload recorded
+// in its state file if there is one. On failure the upload and its state are
+// kept so that a later call can pick up where this one stopped.
+func (c *S3Client) uploadFileMultipart(ctx context.Context, file *os.File, size int64, filePath, objectKey string, digest Digest) error {
+	statePath := c.statePath(filePath)
+	partSize := c.upload.partSizeFor(size)
+
+	state, done := c.resumeUpload(ctx, file, statePath, objectKey, digest, partSize)
+	if state == nil {
+		upload, err := c.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+			Bucket:            aws.String(c.bucketName),
+			Key:               aws.String(objectKey),
+			ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to start multipart upload to bucket %q with key %q: %w", c.bucketName, objectKey, err)
+		}
+		state = &uploadState{
+			Bucket:      c.bucketName,
+			Endpoint:    c.endpoint,
+			Key:         objectKey,
+			UploadID:    aws.ToString(upload.UploadId),
+			ArchivePath: filePath,
+			Digest:      digest,
+			PartSize:    partSize,
+			CreatedAt:   time.Now(),
+		}
+		if err := writeUploadState(statePath, state); err != nil {
+			slog.Warn("Failed to persist upload state, the upload will not be resumable", "path", statePath, "error", err)
+		}
+	}
+
+	number := int32(0)
+	next := func(*[]byte) (int32, io.ReadSeeker, error) {
+		for {
+			number++
+			offset := int64(number-1) * partSize
+			if offset >= size {
+				return 0, nil, io.EOF
+			}
+			if _, ok := done[number]; !ok {
+				return number, io.NewSectionReader(file, offset, min(partSize, size-offset)), nil
+			}
+		}
+	}
+
+	uploadID := aws.String(state.UploadID)
+	parts, err := c.uploadParts(ctx, objectKey, uploadID, next)
+	if err != nil {
+		return fmt.Errorf("%w (upload kept for resume)", err)
+	}
+	for _, part := range done {
+		parts = append(parts, part)
+	}
+
+	if err := c.completeMultipartUpload(ctx, objectKey, uploadID, parts); err != nil {
+		return err
+	}
+	if err := os.Remove(statePath); err != nil && !os.IsNotExist(err) {
+		slog.Warn("Failed to remove upload state", "path", statePath, "error", err)
+	}
+	return nil
+}
+
+// resumeUpload loads the upload state at statePath and lists the parts the
+// bucket already holds. It returns nil when there is nothing to resume; a
+// state that no longer matches the file is discarded. Only parts whose
+// checksum matches that of the same range of file are kept; the others are
+// uploaded again.
+func (c *S3Client) resumeUpload(ctx context.Context, file io.ReaderAt, statePath, objectKey string, digest Digest, partSize int64) (*uploadState, map[int32]types.CompletedPart) {
+	state, err := readUploadState(statePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Ignoring unreadable upload state", "path", statePath, "error", err)
+		}
+		return nil, nil
+	}
+
+	discard := func(reason string) (*uploadState, map[int32]types.CompletedPart) {
+		slog.Info("Discarding previous multipart upload", "key", state.Key, "upload_id", state.UploadID, "reason", reason)
+		c.abortMultipartUpload(state.Key, aws.String(state.UploadID))
+		os.Remove(statePath)
+		return nil, nil
+	}
+	if state.Key != objectKey || state.Digest != digest || state.PartSize != partSize {
+		return discard("the archive changed")
+	}
+	if time.Since(state.CreatedAt) > c.upload.abortIncompleteAfter {
+		return discard("the upload is too old")
+	}
+
+	done := make(map[int32]types.CompletedPart)
+	paginator := s3.NewListPartsPaginator(c.client, &s3.ListPartsInput{
+		Bucket:   aws.String(c.bucketName),
+		Key:      aws.String(objectKey),
+		UploadId: aws.String(state.UploadID),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			slog.Warn("Cannot resume multipart upload, starting over", "key", objectKey, "upload_id", state.UploadID, "error", err)
+			os.Remove(statePath)
+			return nil, nil
+		}
+		if page.ChecksumAlgorithm != types.ChecksumAlgorithmSha256 {
+			// Started without checksums; its parts cannot be completed with them.
+			return discard("the upload has no checksums")
+		}
+		for _, part := range page.Parts {
+			number := aws.ToInt32(part.PartNumber)
+			offset := int64(number-1) * partSize
+			// Only the last part may be shorter than the part size.
+			expected := min(partSize, digest.Size-offset)
+			if aws.ToInt64(part.Size) != expected {
+				continue
+			}
+			checksum, err := checksumSHA256(io.NewSectionReader(file, offset, expected))
+			if err != nil {
+				slog.Warn("Failed to compute checksum of part, uploading it again", "key", objectKey, "part", number, "error", err)
+				continue
+			}
+			if aws.ToString(checksum) != aws.ToString(part.ChecksumSHA256) {
+				slog.Warn("Stored part does not match the archive, uploading it again", "key", objectKey, "part", number)
+				continue
+			}
+			done[number] = types.CompletedPart{ETag: part.ETag, PartNumber: part.PartNumber, ChecksumSHA256: checksum}
+		}
+	}
+
+	slog.Info("Resuming multipart upload", "key", objectKey, "upload_id", state.UploadID, "parts_done", len(done))
+	return state, done
+}
+
+// uploadParts uploads the parts returned by next with up to the configured
+// number of concurrent requests, until next returns io.EOF. Each concurrent
+// upload has a buffer of its own, which next may grow and read a stream's
+// part into; files leave it empty. Each part is retried with exponential
+// backoff before the whole upload is given up.
+func (c *S3Client) uploadParts(ctx context.Context, objectKey string, uploadID *string, next func(buf *[]byte) (int32, io.ReadSeeker, error)) ([]types.CompletedPart, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		parts    []types.CompletedPart
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	for i := 0; i < c.upload.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var buf []byte
+			for {
+				// Parts are read in order, one worker at a time.
+				mu.Lock()
+				if firstErr != nil {
+					mu.Unlock()
+					return
+				}
+				number, body, err := next(&buf)
+				mu.Unlock()
+				if err == io.EOF {
+					return
+				}
+				if err != nil {
+					fail(err)
+					return
+				}
+
+				part, err := c.uploadPart(ctx, objectKey, uploadID, number, body)
+				if err != nil {
+					fail(err)
+					return
+				}
+				mu.Lock()
+				parts = append(parts, part)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return parts, nil
+}
+
+// uploadPart uploads a single part with its SHA-256 checksum, which S3
+// checks on receipt, retrying with exponential backoff.
+func (c *S3Client) uploadPart(ctx context.Context, objectKey string, uploadID *string, number int32, body io.ReadSeeker) (types.CompletedPart, error) {
+	checksum, err := checksumSHA256(body)
+	if err != nil {
+		return types.CompletedPart{}, fmt.Errorf("failed to compute checksum of part %d of key %q: %w", number, objectKey, err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return types.CompletedPart{}, fmt.Errorf("failed to rewind part %d of key %q: %w", number, objectKey, err)
+		}
+
+		output, err := c.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:         aws.String(c.bucketName),
+			Key:            aws.String(objectKey),
+			UploadId:       uploadID,
+			PartNumber:     aws.Int32(number),
+			Body:           body,
+			ChecksumSHA256: checksum,
+		})
+		if err == nil {
+			return types.CompletedPart{ETag: output.ETag, PartNumber: aws.Int32(number), ChecksumSHA256: checksum}, nil
+		}
+		if attempt >= c.upload.maxRetries || ctx.Err() != nil {
+			return types.CompletedPart{}, fmt.Errorf("failed to upload part %d of key %q after %d attempts: %w", number, objectKey, attempt+1, err)
+		}
+
+		delay := retryDelay(attempt)
+		slog.Warn("Retrying part upload", "key", objectKey, "part", number, "attempt", attempt+1, "delay", delay, "error", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return types.CompletedPart{}, ctx.Err()
+		}
+	}
+}
+
+// retryDelay returns the backoff before retry attempt+1: exponential from one
+// second, capped, with up to 50% random jitter.
+func retryDelay(attempt int) time.Duration {
+	delay := maxRetryDelay
+	if attempt < 30 { // Larger shifts would overflow
+		delay = min(time.Second<<attempt, maxRetryDelay)
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// completeMultipartUpload assembles the parts into the object and checks that
+// S3 assembled exactly those parts, by comparing the composite checksum it
+// returns with the one computed from the checksums of the parts.
+func (c *S3Client) completeMultipartUpload(ctx context.Context, objectKey string, uploadID *string, parts []types.CompletedPart) error {
+	sort.Slice(parts, func(i, j int) bool { return aws.ToInt32(parts[i].PartNumber) < aws.ToInt32(parts[j].PartNumber) })
+
+	output, err := c.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(c.bucketName),
+		Key:             aws.String(objectKey),
+		UploadId:        uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload of key %q: %w", objectKey, err)
+	}
+
+	if checksum := aws.ToString(output.ChecksumSHA256); checksum != "" {
+		if expected := compositeChecksum(parts); checksum != expected {
+			return fmt.Errorf("%w: composite checksum of key %q is %s, expected %s", ErrIntegrity, objectKey, checksum, expected)
+		}
+	}
+	return nil
+}
+
+// compositeChecksum computes the checksum S3 reports for an object uploaded
+// in the given parts: the SHA-256 of the concatenated part checksums,
+// followed by the number of parts.
+func compositeChecksum(parts []types.CompletedPart) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		raw, _ := base64.StdEncoding.DecodeString(aws.ToString(part.ChecksumSHA256))
+		hash.Write(raw)
+	}
+	return fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(hash.Sum(nil)), len(parts))
+}
+
+// abortMultipartUpload aborts an upload, even if the job's context is done.
+func (c *S3Client) abortMultipartUpload(objectKey string, uploadID *string) {
+	_, err := c.client.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(c.bucketName),
+		Key:      aws.String(objectKey),
+		UploadId: uploadID,
+	})
+	if err != nil {
+		slog.Error("Failed to abort multipart upload", "bucket", c.bucketName, "key", objectKey, "error", err)
+	}
+}
+
+// AbortStaleUploads aborts the incomplete multipart uploads under prefix that
+// were started longer ago than the destination's abortIncompleteAfter, such
+// as those left behind by crashed runs.
+func (c *S3Client) AbortStaleUploads(ctx context.Context, prefix string) error {
+	cutoff := time.Now().Add(-c.upload.abortIncompleteAfter)
+
+	input := &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(c.bucketName),
+		Prefix: aws.String(prefix),
+	}
+	for {
+		output, err := c.client.ListMultipartUploads(ctx, input)
+		if err != nil {
+			return fmt.Errorf("failed to list multipart uploads in bucket %q: %w", c.bucketName, err)
+		}
+		for _, upload := range output.Uploads {
+			if aws.ToTime(upload.Initiated).After(cutoff) {
+				continue
+			}
+			slog.Info("Aborting stale multipart upload",
+				"bucket", c.bucketName,
+				"key", aws.ToString(upload.Key),
+				"initiated", aws.ToTime(upload.Initiated),
+			)
+			c.abortMultipartUpload(aws.ToString(upload.Key), upload.UploadId)
+		}
+		if !aws.ToBool(output.IsTruncated) {
+			return nil
+		}
+		input.KeyMarker = output.NextKeyMarker
+		input.UploadIdMarker = output.NextUploadIdMarker
+	}
+}
+
+func readUploadState(path string) (*uploadState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state uploadState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func writeUploadState(path string, state *uploadState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// HasPendingUploads reports whether an upload of the archive was interrupted
+// and can be resumed, in which case the archive must be kept.
+func HasPendingUploads(archivePath string) bool {
+	matches, _ := filepath.Glob(globEscape(archivePath) + ".*" + stateExtension)
+	return len(matches) > 0
+}
+
+// ResumeInterruptedUploads finishes the uploads of archives that earlier runs
+// of the job left in its output directory, then removes those archives. Each
+// upload is resumed from the parts already stored on its destination.
+func ResumeInterruptedUploads(ctx context.Context, cfg *models.Config, job models.JobConfig) {
+	pattern := filepath.Join(globEscape(job.Output.Dir), globEscape(job.Output.Name)+"-*.tar*.*"+stateExtension)
+	statePaths, err := filepath.Glob(pattern)
+	if err != nil || len(statePaths) == 0 {
+		return
+	}
+
+	archives := make(map[string]bool)
+	for _, statePath := range statePaths {
+		state, err := readUploadState(statePath)
+		if err != nil {
+			slog.Warn("Ignoring unreadable upload state", "path", statePath, "error", err)
+			continue
+		}
+		if _, ok := util.ParseArchiveName(job.Output.Name, state.ArchivePath); !ok {
+			continue // Another job whose name starts with this one's
+		}
+		archives[state.ArchivePath] = true
+
+		destConfig, ok := findDestination(cfg, job, state)
+		if !ok {
+			slog.Warn("Interrupted upload targets a destination the job no longer uses, discarding it", "path", statePath, "bucket", state.Bucket)
+			os.Remove(statePath)
+			continue
+		}
+		s3Client, err := NewS3Client(ctx, destConfig)
+		if err != nil {
+			slog.Error("Failed to create S3 client to resume upload", "bucket", state.Bucket, "error", err)
+			continue
+		}
+		if time.Since(state.CreatedAt) > s3Client.upload.abortIncompleteAfter {
+			slog.Warn("Interrupted upload is too old to resume, aborting it", "key", state.Key, "bucket", state.Bucket)
+			s3Client.abortMultipartUpload(state.Key, aws.String(state.UploadID))
+			os.Remove(statePath)
+			continue
+		}
+
+		slog.Info("Resuming interrupted upload", "archive_path", state.ArchivePath, "key", state.Key, "bucket", state.Bucket)
+		if err := UploadFile(ctx, s3Client, state.ArchivePath, state.Key, state.Digest); err != nil {
+			slog.Error("Failed to resume interrupted upload", "archive_path", state.ArchivePath, "key", state.Key, "error", err)
+		}
+	}
+
+	for archivePath := range archives {
+		if HasPendingUploads(archivePath) {
+			continue
+		}
+		if err := os.Remove(archivePath); err != nil && !os.IsNotExist(err) {
+			slog.Error("Failed to cleanup archive file", "archivePath", archivePath, "error", err)
+		}
+	}
+}
+
+// findDestination returns the job destination an upload state belongs to.
+func findDestination(cfg *models.Config, job models.JobConfig, state *uploadState) (models.DestinationConfig, bool) {
+	for _, destName := range job.Destinations {
+		destConfig, ok := cfg.Destinations[destName]
+		if ok && isS3Provider(destConfig.Provider) && destConfig.BucketName == state.Bucket && destConfig.EndpointURL == state.Endpoint {
+			return destConfig, true
+		}
+	}
+	return models.DestinationConfig{}, false
+}
+
+// globEscape escapes the glob metacharacters in a literal path.
+func globEscape(path string) string {
+	var b bytes.Buffer
+	for _, r := range path {
+		switch r {
+		case '*', '?', '[', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,187 @@
+package remotestorage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestRetryDelay(t *testing.T) {
+	for _, attempt := range []int{0, 1, 4, 5, 29, 30, 33, 34, 40, 63, 64, 100, 1000} {
+		want := min(time.Second<<min(attempt, 30), maxRetryDelay)
+		for range 100 {
+			delay := retryDelay(attempt)
+			if delay < want/2 || delay > want {
+				t.Fatalf("retryDelay(%d) = %v, want between %v and %v", attempt, delay, want/2, want)
+			}
+		}
+	}
+}
+
+func TestS3UploadStreamGrowsParts(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		wantSizes []int
+		wantErr   bool
+	}{
+		{"single request", 3, nil, false},
+		// Parts double every two parts
+		{"growing parts", 30, []int{4, 4, 8, 8, 6}, false},
+		{"largest stream", 2 * 4 * 1023, []int{4, 4, 8, 8, 16, 16, 32, 32, 64, 64, 128, 128, 256, 256, 512, 512, 1024, 1024, 2048, 2048}, false},
+		{"too large", 2*4*1023 + 1, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := newS3Server(t)
+			client.upload.partSize = 4
+			client.upload.maxParts = 20
+
+			err := client.Upload(context.Background(), strings.NewReader(strings.Repeat("x", tt.size)), "backup.tar.gz", nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Upload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if fake.aborted != 1 {
+					t.Errorf("aborted %d uploads, want 1", fake.aborted)
+				}
+				return
+			}
+
+			var sizes []int
+			for number := 1; number <= len(fake.uploaded); number++ {
+				sizes = append(sizes, fake.uploaded[number])
+			}
+			if !slices.Equal(sizes, tt.wantSizes) {
+				t.Errorf("part sizes = %v, want %v", sizes, tt.wantSizes)
+			}
+		})
+	}
+}
+
+func TestResumeUploadChecksStoredParts(t *testing.T) {
+	const data = "0123456789"
+	tests := []struct {
+		name     string
+		parts    []s3Part
+		wantDone []int32
+	}{
+		{
+			name: "every part matches",
+			parts: []s3Part{
+				{4, sha256Base64("0123")},
+				{4, sha256Base64("4567")},
+				{2, sha256Base64("89")},
+			},
+			wantDone: []int32{1, 2, 3},
+		},
+		{
+			name: "a stored part differs from the archive",
+			parts: []s3Part{
+				{4, sha256Base64("0123")},
+				{4, sha256Base64("45XX")},
+				{2, sha256Base64("89")},
+			},
+			wantDone: []int32{1, 3},
+		},
+		{
+			name: "a stored part is incomplete",
+			parts: []s3Part{
+				{3, sha256Base64("012")},
+				{4, sha256Base64("4567")},
+			},
+			wantDone: []int32{2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := newS3Server(t)
+			fake.parts = tt.parts
+
+			archive := filepath.Join(t.TempDir(), "backup.tar.gz")
+			if err := os.WriteFile(archive, []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			file, err := os.Open(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			digest := digestOf(data)
+			statePath := client.statePath(archive)
+			state := &uploadState{
+				Bucket:      "backups",
+				Key:         "backup.tar.gz",
+				UploadID:    "upload",
+				ArchivePath: archive,
+				Digest:      digest,
+				PartSize:    4,
+				CreatedAt:   time.Now(),
+			}
+			if err := writeUploadState(statePath, state); err != nil {
+				t.Fatal(err)
+			}
+
+			resumed, done := client.resumeUpload(context.Background(), file, statePath, "backup.tar.gz", digest, 4)
+			if resumed == nil {
+				t.Fatal("resumeUpload() discarded the upload")
+			}
+			var got []int32
+			for number, part := range done {
+				got = append(got, number)
+				if aws.ToString(part.ChecksumSHA256) != tt.parts[number-1].checksum {
+					t.Errorf("part %d checksum = %s, want %s", number, aws.ToString(part.ChecksumSHA256), tt.parts[number-1].checksum)
+				}
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.wantDone) {
+				t.Errorf("parts done = %v, want %v", got, tt.wantDone)
+			}
+		})
+	}
+}
+
+func TestResumeUploadDiscardsChangedArchive(t *testing.T) {
+	fake, client := newS3Server(t)
+	archive := filepath.Join(t.TempDir(), "backup.tar.gz")
+	if err := os.WriteFile(archive, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	statePath := client.statePath(archive)
+	state := &uploadState{
+		Bucket:      "backups",
+		Key:         "backup.tar.gz",
+		UploadID:    "upload",
+		ArchivePath: archive,
+		Digest:      digestOf("9876543210"),
+		PartSize:    4,
+		CreatedAt:   time.Now(),
+	}
+	if err := writeUploadState(statePath, state); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, _ := client.resumeUpload(context.Background(), file, statePath, "backup.tar.gz", digestOf("0123456789"), 4)
+	if resumed != nil {
+		t.Error("resumeUpload() resumed the upload of another archive")
+	}
+	if fake.aborted != 1 {
+		t.Errorf("aborted %d uploads, want 1", fake.aborted)
+	}
+	if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+		t.Errorf("upload state was kept: %v", err)
+	}
+}
@@ -2,21 +2,63 @@ package remotestorage
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"log/slog"
+	"io"
 	"os"
-	"path/filepath"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/tderick/backup-companion-go/internal/models"
 )
 
+func init() {
+	provider := Provider{
+		New: func(ctx context.Context, cfg models.DestinationConfig) (Destination, error) {
+			return NewS3Client(ctx, cfg)
+		},
+		CheckConfig: checkS3Config,
+	}
+	Register("s3", provider)
+	Register("minio", provider)
+}
+
+// checkS3Config reports missing settings of an S3 or MinIO destination.
+// Credentials are checked along with the other secrets by the config package.
+func checkS3Config(cfg models.DestinationConfig) error {
+	var missing []string
+	if cfg.BucketName == "" {
+		missing = append(missing, "bucketName")
+	}
+	if cfg.Provider == "s3" && cfg.Region == "" {
+		missing = append(missing, "region")
+	}
+	if cfg.Provider == "minio" && cfg.EndpointURL == "" {
+		missing = append(missing, "endpointUrl")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// isS3Provider reports whether destinations of the provider are S3Clients.
+func isS3Provider(provider string) bool {
+	return provider == "s3" || provider == "minio"
+}
+
+// S3Client is the destination for S3 and S3-compatible storage such as MinIO.
 type S3Client struct {
 	client     *s3.Client
 	bucketName string
+	endpoint   string
+	upload     uploadOptions
 }
 
 func NewS3Client(ctx context.Context, cfg models.DestinationConfig) (*S3Client, error) {
@@ -47,23 +89,40 @@ func NewS3Client(ctx context.Context, cfg models.DestinationConfig) (*S3Client,
 	s3Client := &S3Client{
 		client:     client,
 		bucketName: cfg.BucketName,
+		endpoint:   cfg.EndpointURL,
+		upload:     newUploadOptions(cfg.Upload),
 	}
 
 	return s3Client, nil
 }
 
-func (c *S3Client) UploadFile(ctx context.Context, filePath, objectKey string) error {
+// UploadFile uploads a local file as objectKey. Files larger than one part
+// are sent as a resumable multipart upload; see uploadFileMultipart. Smaller
+// files are sent with the SHA-256 of digest, which S3 checks on receipt.
+func (c *S3Client) UploadFile(ctx context.Context, filePath, objectKey string, digest Digest) error {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to open file %q: %w", filePath, err)
 	}
 	defer file.Close()
 
-	_, err = c.client.PutObject(ctx, &s3.PutObjectInput{
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file %q: %w", filePath, err)
+	}
+	if info.Size() > c.upload.partSize {
+		return c.uploadFileMultipart(ctx, file, info.Size(), filePath, objectKey, digest)
+	}
+
+	input := &s3.PutObjectInput{
 		Bucket: aws.String(c.bucketName),
 		Key:    aws.String(objectKey),
 		Body:   file,
-	})
+	}
+	if checksum := hexToBase64(digest.SHA256); checksum != "" {
+		input.ChecksumSHA256 = aws.String(checksum)
+	}
+	_, err = c.client.PutObject(ctx, input)
 	if err != nil {
 		return fmt.Errorf("failed to upload file %q to bucket %q with key %q: %w", filePath, c.bucketName, objectKey, err)
 	}
@@ -71,75 +130,120 @@ func (c *S3Client) UploadFile(ctx context.Context, filePath, objectKey string) e
 	return nil
 }
 
-func (c *S3Client) ValidateConnection(ctx context.Context) error {
-	// HeadBucket is a lightweight, non-destructive way to check for bucket existence
-	// and access permissions. It's an ideal choice for validating the connection.
-	_, err := c.client.HeadBucket(ctx, &s3.HeadBucketInput{
+// Download opens an object in the bucket for reading.
+func (c *S3Client) Download(ctx context.Context, objectKey string) (io.ReadCloser, error) {
+	output, err := c.client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(c.bucketName),
+		Key:    aws.String(objectKey),
 	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			err = fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+		return nil, fmt.Errorf("failed to download object %q from bucket %q: %w", objectKey, c.bucketName, err)
+	}
+	return output.Body, nil
+}
 
+// Stat describes an object in the bucket, including its metadata and its
+// full-object checksums. The composite checksums of multipart uploads are
+// checked when the upload completes instead.
+func (c *S3Client) Stat(ctx context.Context, objectKey string) (ObjectInfo, error) {
+	output, err := c.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(c.bucketName),
+		Key:          aws.String(objectKey),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
 	if err != nil {
-		// Provide a more descriptive error message that includes the original error.
-		return fmt.Errorf("failed to validate S3 connection for bucket %q: %w", c.bucketName, err)
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			err = fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+		return ObjectInfo{}, fmt.Errorf("failed to stat object %q in bucket %q: %w", objectKey, c.bucketName, err)
 	}
-	// If no error is returned, the connection and access to the bucket are considered valid.
-	return nil
+	return ObjectInfo{
+		Key:          objectKey,
+		Size:         aws.ToInt64(output.ContentLength),
+		LastModified: aws.ToTime(output.LastModified),
+		Metadata:     output.Metadata,
+		Checksums: Checksums{
+			SHA256: fullObjectChecksum(output.ChecksumSHA256),
+			CRC32C: fullObjectChecksum(output.ChecksumCRC32C),
+		},
+	}, nil
+}
+
+// fullObjectChecksum converts a checksum returned by S3 to hex, leaving out
+// composite checksums, which end with the number of parts, e.g. "...-3".
+func fullObjectChecksum(checksum *string) string {
+	if strings.Contains(aws.ToString(checksum), "-") {
+		return ""
+	}
+	return base64ToHex(aws.ToString(checksum))
+}
+
+// checksumSHA256 returns the base64-encoded SHA-256 of body, as S3 expects
+// it, and rewinds body.
+func checksumSHA256(body io.ReadSeeker) (*string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, body); err != nil {
+		return nil, err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return aws.String(base64.StdEncoding.EncodeToString(hash.Sum(nil))), nil
 }
 
-func UploadArchiveToDestinations(ctx context.Context, cfg *models.Config, job models.JobConfig, archivePath string) error {
-	objectKey := filepath.Base(archivePath) // The name of the file in the S3 bucket
-
-	var uploadErrors []error
-	for _, destName := range job.Destinations {
-		if destConfig, ok := cfg.Destinations[destName]; ok {
-			slog.Info("Attempting to upload archive to destination",
-				"archive_key", objectKey,
-				"destination", destName,
-				"provider", destConfig.Provider,
-				"job_name", job.Output.Name,
-			)
-
-			s3Client, err := NewS3Client(ctx, destConfig)
-			if err != nil {
-				err := fmt.Errorf("failed to create S3 client for destination %q: %w", destName, err)
-				slog.Error("Failed to create S3 client for upload, skipping destination",
-					"destination", destName,
-					"error", err,
-					"job_name", job.Output.Name,
-				)
-				uploadErrors = append(uploadErrors, err)
-				continue
-			}
-
-			if err := s3Client.UploadFile(ctx, archivePath, objectKey); err != nil {
-				err := fmt.Errorf("failed to upload archive %q to destination %q: %w", objectKey, destName, err)
-				slog.Error("Failed to upload archive to destination",
-					"archive_key", objectKey,
-					"destination", destName,
-					"error", err,
-					"job_name", job.Output.Name,
-				)
-				uploadErrors = append(uploadErrors, err)
-			} else {
-				slog.Info("Successfully uploaded archive to destination",
-					"archive_key", objectKey,
-					"destination", destName,
-					"job_name", job.Output.Name,
-				)
-			}
-		} else {
-			err := fmt.Errorf("destination %q referenced by job %q not found in config during upload", destName, job.Output.Name)
-			slog.Error("Destination not found in config during upload (should have been caught by earlier validation)",
-				"destination", destName,
-				"job_name", job.Output.Name,
-				"error", err,
-			)
-			uploadErrors = append(uploadErrors, err)
+// List returns every object in the bucket whose key starts with prefix.
+func (c *S3Client) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	paginator := s3.NewListObjectsV2Paginator(c.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(c.bucketName),
+		Prefix: aws.String(prefix),
+	})
+
+	var objects []ObjectInfo
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects in bucket %q with prefix %q: %w", c.bucketName, prefix, err)
+		}
+		for _, object := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          aws.ToString(object.Key),
+				Size:         aws.ToInt64(object.Size),
+				LastModified: aws.ToTime(object.LastModified),
+			})
 		}
 	}
+	return objects, nil
+}
 
-	if len(uploadErrors) > 0 {
-		return fmt.Errorf("encountered errors during archive upload: %v", uploadErrors)
+// Delete removes an object from the bucket.
+func (c *S3Client) Delete(ctx context.Context, objectKey string) error {
+	_, err := c.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(c.bucketName),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete object %q from bucket %q: %w", objectKey, c.bucketName, err)
 	}
 	return nil
 }
+
+// Validate checks that the bucket exists and is accessible.
+func (c *S3Client) Validate(ctx context.Context) error {
+	// HeadBucket is a lightweight, non-destructive way to check for bucket existence
+	// and access permissions. It's an ideal choice for validating the connection.
+	_, err := c.client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(c.bucketName),
+	})
+
+	if err != nil {
+		// Provide a more descriptive error message that includes the original error.
+		return fmt.Errorf("failed to validate S3 connection for bucket %q: %w", c.bucketName, err)
+	}
+	// If no error is returned, the connection and access to the bucket are considered valid.
+	return nil
+}
@@ -0,0 +1,182 @@
+package remotestorage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+// s3Part is a part of a multipart upload held by the test S3 server.
+type s3Part struct {
+	size     int
+	checksum string
+}
+
+// s3Server implements the subset of the S3 API needed to upload small files
+// and to resume multipart uploads, for the bucket "backups".
+type s3Server struct {
+	mu sync.Mutex
+	// objects and checksums hold each uploaded object and the SHA-256 sent
+	// with it.
+	objects   map[string][]byte
+	checksums map[string]string
+	// sent is the SHA-256 sent with the last upload.
+	sent string
+	// parts are returned by ListParts for any upload.
+	parts []s3Part
+	// aborted counts aborted multipart uploads.
+	aborted int
+	// uploaded holds the size of every part uploaded, by part number, and
+	// completed counts completed multipart uploads.
+	uploaded  map[int]int
+	completed int
+}
+
+func newS3Server(t *testing.T) (*s3Server, *S3Client) {
+	t.Helper()
+	fake := &s3Server{objects: make(map[string][]byte), checksums: make(map[string]string), uploaded: make(map[int]int)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client, err := NewS3Client(context.Background(), models.DestinationConfig{
+		Provider:        "minio",
+		BucketName:      "backups",
+		EndpointURL:     server.URL,
+		Region:          "us-east-1",
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, client
+}
+
+func (s *s3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, ok := strings.CutPrefix(r.URL.Path, "/backups/")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && query.Has("uploadId"):
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><ListPartsResult><Bucket>backups</Bucket><Key>%s</Key><UploadId>%s</UploadId><ChecksumAlgorithm>SHA256</ChecksumAlgorithm><IsTruncated>false</IsTruncated>`,
+			key, query.Get("uploadId"))
+		for i, part := range s.parts {
+			fmt.Fprintf(w, `<Part><PartNumber>%d</PartNumber><ETag>"etag-%d"</ETag><Size>%d</Size><ChecksumSHA256>%s</ChecksumSHA256></Part>`,
+				i+1, i+1, part.size, part.checksum)
+		}
+		io.WriteString(w, `</ListPartsResult>`)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><InitiateMultipartUploadResult><Bucket>backups</Bucket><Key>%s</Key><UploadId>upload</UploadId></InitiateMultipartUploadResult>`, key)
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		data, err := io.ReadAll(r.Body)
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		if err != nil || number == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.uploaded[number] = len(data)
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, number))
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		s.completed++
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><CompleteMultipartUploadResult><Bucket>backups</Bucket><Key>%s</Key><ETag>"etag"</ETag></CompleteMultipartUploadResult>`, key)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		s.aborted++
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// Like S3, reject data that does not match its checksum.
+		s.sent = r.Header.Get("x-amz-checksum-sha256")
+		if s.sent != "" && s.sent != sha256Base64(string(data)) {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>BadDigest</Code></Error>`)
+			return
+		}
+		s.objects[key] = data
+		s.checksums[key] = s.sent
+		w.Header().Set("ETag", `"etag"`)
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodHead:
+		data, ok := s.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		if checksum := s.checksums[key]; checksum != "" {
+			w.Header().Set("x-amz-checksum-sha256", checksum)
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// sha256Base64 returns the base64-encoded SHA-256 of data, as S3 expects it.
+func sha256Base64(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// digestOf returns the digest of data.
+func digestOf(data string) Digest {
+	sum := NewChecksummer()
+	io.WriteString(sum, data)
+	return sum.Digest()
+}
+
+func TestS3UploadFile(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newS3Server(t)
+	archive := filepath.Join(t.TempDir(), "backup.tar.gz")
+	if err := os.WriteFile(archive, []byte("archive contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		digest  Digest
+		wantErr bool
+	}{
+		{"matching digest", digestOf("archive contents"), false},
+		// The archive was changed after it was written
+		{"archive changed", digestOf("archive CONTENTS"), true},
+		{"archive truncated", digestOf("archive contents and more"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := UploadFile(ctx, client, archive, "backup.tar.gz", tt.digest)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UploadFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			// The checksum sent is that of the digest, not of the file.
+			if got, want := fake.sent, hexToBase64(tt.digest.SHA256); got != want {
+				t.Errorf("sent SHA-256 %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,415 @@
+package remotestorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/tderick/backup-companion-go/internal/models"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	defaultSFTPPort = 22
+	sftpDialTimeout = 30 * time.Second
+)
+
+func init() {
+	Register("sftp", Provider{
+		New: func(ctx context.Context, cfg models.DestinationConfig) (Destination, error) {
+			return NewSFTPDestination(cfg)
+		},
+		CheckConfig: checkSFTPConfig,
+	})
+}
+
+// checkSFTPConfig reports missing settings of an SFTP destination.
+// The password is checked along with the other secrets by the config package.
+func checkSFTPConfig(cfg models.DestinationConfig) error {
+	var missing []string
+	if cfg.Host == "" {
+		missing = append(missing, "host")
+	}
+	if cfg.User == "" {
+		missing = append(missing, "user")
+	}
+	if cfg.Path == "" {
+		missing = append(missing, "path")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+	if cfg.Port < 0 || cfg.Port > 65535 {
+		return fmt.Errorf("invalid port %d", cfg.Port)
+	}
+	return nil
+}
+
+// SFTPDestination stores objects as files below a directory on an SFTP
+// server. Every operation opens its own connection, so that no connection
+// outlives the run that needed it.
+type SFTPDestination struct {
+	address string
+	root    string
+	config  *ssh.ClientConfig
+	upload  uploadOptions
+}
+
+// NewSFTPDestination prepares an SFTP destination. It reads the private key
+// and known_hosts files but does not connect yet.
+func NewSFTPDestination(cfg models.DestinationConfig) (*SFTPDestination, error) {
+	var auth []ssh.AuthMethod
+	if cfg.PrivateKeyFile != "" {
+		key, err := os.ReadFile(cfg.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key %q: %w", cfg.PrivateKeyFile, err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key %q: %w", cfg.PrivateKeyFile, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if cfg.Password != "" {
+		auth = append(auth, ssh.Password(cfg.Password))
+	}
+
+	knownHostsFile := cfg.KnownHostsFile
+	if knownHostsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate known_hosts file: %w", err)
+		}
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known_hosts file %q: %w", knownHostsFile, err)
+	}
+
+	port := cfg.Port
+	if port == 0 {
+		port = defaultSFTPPort
+	}
+
+	return &SFTPDestination{
+		address: net.JoinHostPort(cfg.Host, strconv.Itoa(port)),
+		root:    path.Clean(cfg.Path),
+		config: &ssh.ClientConfig{
+			User:            cfg.User,
+			Auth:            auth,
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         sftpDialTimeout,
+		},
+		upload: newUploadOptions(cfg.Upload),
+	}, nil
+}
+
+// sftpSession is an SFTP client together with the SSH connection it runs on.
+type sftpSession struct {
+	*sftp.Client
+	conn *ssh.Client
+	stop func() bool
+}
+
+func (s *sftpSession) Close() error {
+	s.stop()
+	return errors.Join(s.Client.Close(), s.conn.Close())
+}
+
+// connect opens a new SFTP session to the server.
+func (d *SFTPDestination) connect(ctx context.Context) (*sftpSession, error) {
+	dialer := net.Dialer{Timeout: sftpDialTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", d.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %q: %w", d.address, err)
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, d.address, d.config)
+	if err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("failed to establish SSH connection to %q: %w", d.address, err)
+	}
+	conn := ssh.NewClient(sshConn, chans, reqs)
+
+	client, err := sftp.NewClient(conn, sftp.UseConcurrentWrites(true))
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start SFTP session on %q: %w", d.address, err)
+	}
+
+	// Closing the connection interrupts any operation in progress.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	return &sftpSession{Client: client, conn: conn, stop: stop}, nil
+}
+
+// objectPath returns the remote file an object is stored in, rejecting keys
+// that would escape the destination directory.
+func (d *SFTPDestination) objectPath(objectKey string) (string, error) {
+	name := path.Clean("/" + objectKey)
+	if name == "/" || strings.HasPrefix(path.Base(name), tempPrefix) || strings.HasSuffix(name, metadataExtension) {
+		return "", fmt.Errorf("invalid object key %q", objectKey)
+	}
+	return path.Join(d.root, name), nil
+}
+
+// Validate checks that the remote directory exists and is writable.
+func (d *SFTPDestination) Validate(ctx context.Context) error {
+	session, err := d.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	info, err := session.Stat(d.root)
+	if err != nil {
+		return fmt.Errorf("failed to access remote directory %q: %w", d.root, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("remote path %q is not a directory", d.root)
+	}
+
+	probe := path.Join(d.root, tempPrefix+"validate-"+strconv.FormatInt(time.Now().UnixNano(), 36))
+	file, err := session.Create(probe)
+	if err != nil {
+		return fmt.Errorf("remote directory %q is not writable: %w", d.root, err)
+	}
+	file.Close()
+	return session.Remove(probe)
+}
+
+// Upload writes everything read from r to a temporary remote file and renames
+// it into place, so that an object is either complete or absent.
+func (d *SFTPDestination) Upload(ctx context.Context, r io.Reader, objectKey string, metadata map[string]string) error {
+	target, err := d.objectPath(objectKey)
+	if err != nil {
+		return err
+	}
+
+	session, err := d.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	if err := session.MkdirAll(path.Dir(target)); err != nil {
+		return fmt.Errorf("failed to create remote directory for %q: %w", target, err)
+	}
+
+	if len(metadata) > 0 {
+		data, err := json.Marshal(metadata)
+		if err != nil {
+			return fmt.Errorf("failed to encode metadata of %q: %w", objectKey, err)
+		}
+		if err := session.writeFileAtomic(target+metadataExtension, bytes.NewReader(data)); err != nil {
+			return err
+		}
+	}
+
+	if err := session.writeFileAtomic(target, r); err != nil {
+		return err
+	}
+	slog.Debug("Wrote object to SFTP destination", "address", d.address, "path", target)
+	return nil
+}
+
+// writeFileAtomic writes the contents of r to target through a temporary file
+// in the same directory, so that target is replaced only once fully written.
+func (s *sftpSession) writeFileAtomic(target string, r io.Reader) (err error) {
+	dir, base := path.Split(target)
+	temp := path.Join(dir, tempPrefix+base+"-"+strconv.FormatInt(time.Now().UnixNano(), 36))
+
+	file, err := s.OpenFile(temp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for %q: %w", target, err)
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			s.Remove(temp)
+		}
+	}()
+
+	if _, err = io.Copy(file, r); err != nil {
+		return fmt.Errorf("failed to write %q: %w", target, err)
+	}
+	if err = file.Close(); err != nil {
+		return fmt.Errorf("failed to close %q: %w", target, err)
+	}
+
+	// Plain SFTP renames refuse to replace an existing file; the OpenSSH
+	// extension does so atomically where the server supports it.
+	if err = s.PosixRename(temp, target); err != nil {
+		s.Remove(target)
+		if err = s.Rename(temp, target); err != nil {
+			return fmt.Errorf("failed to move %q into place: %w", target, err)
+		}
+	}
+	return nil
+}
+
+// List returns every object whose key starts with prefix.
+func (d *SFTPDestination) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	session, err := d.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	var objects []ObjectInfo
+	walker := session.Walk(d.root)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return nil, fmt.Errorf("failed to list remote directory %q: %w", d.root, err)
+		}
+		info := walker.Stat()
+		if info.IsDir() || strings.HasPrefix(info.Name(), tempPrefix) || strings.HasSuffix(info.Name(), metadataExtension) {
+			continue
+		}
+
+		key := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), d.root), "/")
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+	}
+	return objects, nil
+}
+
+// Download opens an object for reading. Closing it also closes the session.
+func (d *SFTPDestination) Download(ctx context.Context, objectKey string) (io.ReadCloser, error) {
+	target, err := d.objectPath(objectKey)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := d.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := session.Open(target)
+	if err != nil {
+		session.Close()
+		if errors.Is(err, fs.ErrNotExist) {
+			err = fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+		return nil, fmt.Errorf("failed to open remote object %q: %w", objectKey, err)
+	}
+	return &sftpDownload{File: file, session: session}, nil
+}
+
+// sftpDownload is a remote file whose session is closed along with it.
+type sftpDownload struct {
+	*sftp.File
+	session *sftpSession
+}
+
+func (d *sftpDownload) Close() error {
+	return errors.Join(d.File.Close(), d.session.Close())
+}
+
+// Delete removes an object and its metadata.
+func (d *SFTPDestination) Delete(ctx context.Context, objectKey string) error {
+	target, err := d.objectPath(objectKey)
+	if err != nil {
+		return err
+	}
+
+	session, err := d.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	if err := session.Remove(target); err != nil {
+		return fmt.Errorf("failed to delete remote object %q: %w", objectKey, err)
+	}
+	if err := session.Remove(target + metadataExtension); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete metadata of remote object %q: %w", objectKey, err)
+	}
+	return nil
+}
+
+// Stat describes an object, including its metadata.
+func (d *SFTPDestination) Stat(ctx context.Context, objectKey string) (ObjectInfo, error) {
+	target, err := d.objectPath(objectKey)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	session, err := d.connect(ctx)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer session.Close()
+
+	info, err := session.Stat(target)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+		return ObjectInfo{}, fmt.Errorf("failed to stat remote object %q: %w", objectKey, err)
+	}
+
+	object := ObjectInfo{Key: objectKey, Size: info.Size(), LastModified: info.ModTime()}
+	file, err := session.Open(target + metadataExtension)
+	if err == nil {
+		err = json.NewDecoder(file).Decode(&object.Metadata)
+		file.Close()
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return ObjectInfo{}, fmt.Errorf("failed to read metadata of remote object %q: %w", objectKey, err)
+	}
+	return object, nil
+}
+
+// AbortStaleUploads removes the temporary files of objects starting with
+// prefix that were last written longer ago than the destination's
+// abortIncompleteAfter, such as those left behind by crashed runs.
+func (d *SFTPDestination) AbortStaleUploads(ctx context.Context, prefix string) error {
+	cutoff := time.Now().Add(-d.upload.abortIncompleteAfter)
+
+	session, err := d.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	dirPrefix, namePrefix := splitPrefix(prefix)
+	dir := path.Join(d.root, dirPrefix)
+	entries, err := session.ReadDirContext(ctx, dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to list remote directory %q: %w", dir, err)
+	}
+
+	var removeErrors []error
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), tempPrefix+namePrefix) || entry.ModTime().After(cutoff) {
+			continue
+		}
+		leftover := path.Join(dir, entry.Name())
+		slog.Info("Removing stale incomplete upload", "address", d.address, "path", leftover, "modified", entry.ModTime())
+		if err := session.Remove(leftover); err != nil {
+			removeErrors = append(removeErrors, err)
+		}
+	}
+	return errors.Join(removeErrors...)
+}
@@ -0,0 +1,273 @@
+package remotestorage
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/tderick/backup-companion-go/internal/models"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	testSFTPUser     = "backup"
+	testSFTPPassword = "secret"
+)
+
+// startSFTPServer serves the local filesystem over SFTP on a random port and
+// returns its address and host key.
+func startSFTPServer(t *testing.T) (string, ssh.PublicKey) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == testSFTPUser && string(password) == testSFTPPassword {
+				return nil, nil
+			}
+			return nil, errors.New("access denied")
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, config)
+		}
+	}()
+	return listener.Addr().String(), signer.PublicKey()
+}
+
+// serveSSH runs an SFTP server for every session of an SSH connection that
+// requests the sftp subsystem.
+func serveSSH(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				go func() {
+					defer channel.Close()
+					server, err := sftp.NewServer(channel)
+					if err != nil {
+						return
+					}
+					server.Serve()
+					server.Close()
+				}()
+			}
+		}()
+	}
+}
+
+// newTestSFTPDestination returns a destination storing objects in a
+// temporary directory through the server at addr, trusting hostKey.
+func newTestSFTPDestination(t *testing.T, addr string, hostKey ssh.PublicKey) (*SFTPDestination, string) {
+	t.Helper()
+	root := t.TempDir()
+
+	knownHostsFile := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostKey)
+	if err := os.WriteFile(knownHostsFile, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	host, portText, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dest, err := NewSFTPDestination(models.DestinationConfig{
+		Provider:       "sftp",
+		Host:           host,
+		Port:           port,
+		User:           testSFTPUser,
+		Password:       testSFTPPassword,
+		KnownHostsFile: knownHostsFile,
+		Path:           root,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dest, root
+}
+
+// checkingReader returns data in two reads and calls check in between.
+type checkingReader struct {
+	data  []byte
+	reads int
+	check func()
+}
+
+func (r *checkingReader) Read(p []byte) (int, error) {
+	r.reads++
+	switch r.reads {
+	case 1:
+		return copy(p, r.data[:len(r.data)/2]), nil
+	case 2:
+		r.check()
+		return copy(p, r.data[len(r.data)/2:]), nil
+	default:
+		return 0, io.EOF
+	}
+}
+
+func TestSFTPDestination(t *testing.T) {
+	ctx := context.Background()
+	addr, hostKey := startSFTPServer(t)
+	dest, root := newTestSFTPDestination(t, addr, hostKey)
+
+	if err := dest.Validate(ctx); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	// Upload writes to a temporary name and only renames it once complete
+	data := []byte("archive contents")
+	var sawTemp bool
+	r := &checkingReader{data: data, check: func() {
+		entries, err := os.ReadDir(filepath.Join(root, "host"))
+		if err != nil {
+			t.Errorf("failed to read upload directory: %v", err)
+			return
+		}
+		for _, entry := range entries {
+			if entry.Name() == "backup.tar.gz" {
+				t.Error("object visible before its upload completed")
+			}
+			sawTemp = sawTemp || strings.HasPrefix(entry.Name(), tempPrefix+"backup.tar.gz-")
+		}
+	}}
+	metadata := map[string]string{"sha256": "abc"}
+	if err := dest.Upload(ctx, r, "host/backup.tar.gz", metadata); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if !sawTemp {
+		t.Error("upload did not go through a temporary file")
+	}
+	if err := dest.Upload(ctx, strings.NewReader("other"), "other.tar.gz", nil); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	// Replacing an existing object
+	if err := dest.Upload(ctx, strings.NewReader("newer"), "other.tar.gz", nil); err != nil {
+		t.Fatalf("Upload() replacing an object error = %v", err)
+	}
+
+	objects, err := dest.List(ctx, "")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	var keys []string
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "host/backup.tar.gz,other.tar.gz" {
+		t.Errorf("List() keys = %v, want host/backup.tar.gz and other.tar.gz", keys)
+	}
+	if objects, err := dest.List(ctx, "host/"); err != nil || len(objects) != 1 || objects[0].Size != int64(len(data)) {
+		t.Errorf("List(host/) = %+v, %v, want host/backup.tar.gz of %d bytes", objects, err, len(data))
+	}
+
+	body, err := dest.Download(ctx, "host/backup.tar.gz")
+	if err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	got, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || string(got) != string(data) {
+		t.Errorf("Download() = %q, %v, want %q", got, err, data)
+	}
+	if _, err := dest.Download(ctx, "missing.tar.gz"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Download() of a missing object error = %v, want ErrNotFound", err)
+	}
+
+	info, err := dest.Stat(ctx, "host/backup.tar.gz")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Size != int64(len(data)) || info.Metadata["sha256"] != "abc" {
+		t.Errorf("Stat() = %+v, want size %d and the uploaded metadata", info, len(data))
+	}
+	if _, err := dest.Stat(ctx, "missing.tar.gz"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat() of a missing object error = %v, want ErrNotFound", err)
+	}
+
+	if err := dest.Delete(ctx, "host/backup.tar.gz"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	for _, name := range []string{"backup.tar.gz", "backup.tar.gz" + metadataExtension} {
+		if _, err := os.Stat(filepath.Join(root, "host", name)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s still exists after Delete(): %v", name, err)
+		}
+	}
+}
+
+func TestSFTPDestinationRejectsUnknownHostKey(t *testing.T) {
+	addr, _ := startSFTPServer(t)
+
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSigner, err := ssh.NewSignerFromKey(otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest, _ := newTestSFTPDestination(t, addr, otherSigner.PublicKey())
+
+	err = dest.Validate(context.Background())
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		t.Fatalf("Validate() error = %v, want a host key mismatch", err)
+	}
+}
@@ -0,0 +1,196 @@
+package remotestorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/tderick/backup-companion-go/internal/backup/encryption"
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+// StreamArchiveToDestinations uploads an archive to every destination of the
+// job, as the object key returned by archiveKey, while it is being produced,
+// without writing it to disk. produce writes
+// the archive to the given writer, which fans it out to one multipart upload
+// per destination; a destination that fails drops out without affecting the
+// others. If produce fails, every upload is aborted. It returns the outcome
+// of each upload and the number of bytes produced.
+func StreamArchiveToDestinations(ctx context.Context, cfg *models.Config, job models.JobConfig, archiveKey KeyFunc, produce func(w io.Writer) error) ([]models.DestinationResult, int64, error) {
+	start := time.Now()
+
+	streams := make([]*uploadStream, 0, len(job.Destinations))
+	for _, destName := range job.Destinations {
+		archive, err := archiveKey(destName)
+		if err != nil {
+			streams = append(streams, &uploadStream{destName: destName, err: err})
+			continue
+		}
+		streams = append(streams, startUploadStream(ctx, cfg, job, destName, archive))
+	}
+
+	out := &fanoutWriter{streams: streams}
+	produceErr := produce(out)
+	if produceErr != nil {
+		produceErr = fmt.Errorf("failed to produce archive: %w", produceErr)
+	}
+
+	results := make([]models.DestinationResult, 0, len(streams))
+	for _, stream := range streams {
+		err := stream.finish(produceErr)
+		result := models.DestinationResult{
+			Name:      stream.destName,
+			ObjectKey: stream.objectKey,
+			Status:    models.StatusSuccess,
+			Duration:  time.Since(start),
+		}
+		if err != nil {
+			result.Status = models.StatusFailed
+			result.Error = err.Error()
+			slog.Error("Failed to stream archive to destination",
+				"archive_key", stream.objectKey,
+				"destination", stream.destName,
+				"error", err,
+				"job_name", job.Output.Name,
+			)
+		} else {
+			slog.Info("Successfully streamed archive to destination",
+				"archive_key", stream.objectKey,
+				"destination", stream.destName,
+				"job_name", job.Output.Name,
+			)
+		}
+		results = append(results, result)
+	}
+	return results, out.written, produceErr
+}
+
+// errUploadEnded fails writes to a stream whose upload has already completed.
+var errUploadEnded = errors.New("upload ended before the archive was complete")
+
+// uploadStream is an upload to one destination fed through a pipe.
+type uploadStream struct {
+	destName  string
+	objectKey string
+	pw        *io.PipeWriter
+	// w is what the archive is written to: pw, or an encrypting writer over it.
+	w    io.WriteCloser
+	done chan error
+	// err is the first error that made the stream drop out.
+	err error
+}
+
+// startUploadStream starts uploading whatever is written to the returned
+// stream. Setup errors are recorded on the stream, which then ignores writes.
+func startUploadStream(ctx context.Context, cfg *models.Config, job models.JobConfig, destName string, archive ArchiveKey) *uploadStream {
+	stream := &uploadStream{destName: destName, objectKey: archive.Key}
+
+	destConfig, ok := cfg.Destinations[destName]
+	if !ok {
+		stream.err = fmt.Errorf("destination %q referenced by job %q not found in config during upload", destName, job.Output.Name)
+		return stream
+	}
+
+	dest, err := New(ctx, destConfig)
+	if err != nil {
+		stream.err = fmt.Errorf("failed to create client for destination %q: %w", destName, err)
+		return stream
+	}
+
+	abortStaleUploads(ctx, dest, destName, archive.JobPrefix)
+
+	var metadata map[string]string
+	enc := encryption.Effective(job, destConfig)
+	if enc != nil {
+		stream.objectKey += encryption.Extension
+		metadata = map[string]string{encryption.MetadataKey: encryption.Scheme(*enc)}
+	}
+
+	slog.Info("Streaming archive to destination",
+		"archive_key", stream.objectKey,
+		"destination", destName,
+		"provider", destConfig.Provider,
+		"job_name", job.Output.Name,
+	)
+
+	pr, pw := io.Pipe()
+	stream.pw, stream.w = pw, pw
+	stream.done = make(chan error, 1)
+	go func() {
+		err := uploadVerified(ctx, dest, pr, stream.objectKey, metadata)
+		// Fail any further write instead of blocking forever.
+		if err != nil {
+			pr.CloseWithError(err)
+		} else {
+			pr.CloseWithError(errUploadEnded)
+		}
+		stream.done <- err
+	}()
+
+	// The upload must already be reading: the encrypting writer writes its
+	// header to the pipe straight away.
+	if enc != nil {
+		if stream.w, err = encryption.Encrypt(pw, *enc); err != nil {
+			stream.err = err
+		}
+	}
+	return stream
+}
+
+// finish ends the stream and waits for its upload. If cause is set, the
+// upload is aborted rather than completed.
+func (s *uploadStream) finish(cause error) error {
+	if s.done == nil {
+		return s.err // The upload was never started.
+	}
+
+	if cause == nil && s.err == nil {
+		if err := s.w.Close(); err != nil {
+			s.err = err
+		}
+	}
+	if cause != nil {
+		s.pw.CloseWithError(cause)
+	} else if s.err != nil {
+		s.pw.CloseWithError(s.err)
+	} else {
+		s.pw.Close()
+	}
+
+	uploadErr := <-s.done
+	if cause != nil {
+		return cause
+	}
+	if s.err != nil {
+		return s.err
+	}
+	return uploadErr
+}
+
+// fanoutWriter writes to every upload stream that has not failed yet.
+type fanoutWriter struct {
+	streams []*uploadStream
+	written int64
+}
+
+func (f *fanoutWriter) Write(p []byte) (int, error) {
+	alive := 0
+	for _, stream := range f.streams {
+		if stream.err != nil {
+			continue
+		}
+		if _, err := stream.w.Write(p); err != nil {
+			stream.err = err
+			continue
+		}
+		alive++
+	}
+	if alive == 0 {
+		return 0, errors.New("every destination failed")
+	}
+	f.written += int64(len(p))
+	return len(p), nil
+}
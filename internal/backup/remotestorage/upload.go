@@ -0,0 +1,211 @@
+package remotestorage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/tderick/backup-companion-go/internal/backup/encryption"
+	"github.com/tderick/backup-companion-go/internal/backup/manifest"
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+// ArchiveKey locates an archive on a destination.
+type ArchiveKey struct {
+	// Key is the object key of the archive, before any encryption extension.
+	Key string
+	// JobPrefix is shared by the keys of every archive of the job on the
+	// destination; stale uploads are looked for under it.
+	JobPrefix string
+}
+
+// KeyFunc returns where an archive is stored on the named destination.
+type KeyFunc func(destName string) (ArchiveKey, error)
+
+// UploadArchiveToDestinations uploads the archive to every destination of the
+// job, as the object key returned by archiveKey, and reports the outcome of
+// each upload. Each upload is verified against digest, computed while the
+// archive was written.
+func UploadArchiveToDestinations(ctx context.Context, cfg *models.Config, job models.JobConfig, archivePath string, digest Digest, archiveKey KeyFunc) []models.DestinationResult {
+	results := make([]models.DestinationResult, 0, len(job.Destinations))
+	for _, destName := range job.Destinations {
+		start := time.Now()
+		var key string
+		archive, err := archiveKey(destName)
+		if err == nil {
+			key, err = uploadArchive(ctx, cfg, job, destName, archivePath, digest, archive)
+		} else {
+			slog.Error("Failed to compute object key, skipping destination", "destination", destName, "error", err, "job_name", job.Output.Name)
+		}
+
+		result := models.DestinationResult{
+			Name:      destName,
+			ObjectKey: key,
+			Status:    models.StatusSuccess,
+			Duration:  time.Since(start),
+		}
+		if err != nil {
+			result.Status = models.StatusFailed
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// uploadArchive uploads the archive to a single destination.
+// Archives are encrypted on the fly when the job or destination asks for it,
+// in which case the returned object key carries the encryption extension.
+func uploadArchive(ctx context.Context, cfg *models.Config, job models.JobConfig, destName, archivePath string, digest Digest, archive ArchiveKey) (string, error) {
+	objectKey := archive.Key
+	destConfig, ok := cfg.Destinations[destName]
+	if !ok {
+		err := fmt.Errorf("destination %q referenced by job %q not found in config during upload", destName, job.Output.Name)
+		slog.Error("Destination not found in config during upload (should have been caught by earlier validation)",
+			"destination", destName,
+			"job_name", job.Output.Name,
+			"error", err,
+		)
+		return objectKey, err
+	}
+
+	slog.Info("Attempting to upload archive to destination",
+		"archive_key", objectKey,
+		"destination", destName,
+		"provider", destConfig.Provider,
+		"job_name", job.Output.Name,
+	)
+
+	dest, err := New(ctx, destConfig)
+	if err != nil {
+		err := fmt.Errorf("failed to create client for destination %q: %w", destName, err)
+		slog.Error("Failed to create client for upload, skipping destination",
+			"destination", destName,
+			"error", err,
+			"job_name", job.Output.Name,
+		)
+		return objectKey, err
+	}
+
+	abortStaleUploads(ctx, dest, destName, archive.JobPrefix)
+
+	enc := encryption.Effective(job, destConfig)
+	if enc != nil {
+		objectKey += encryption.Extension
+		err = uploadEncrypted(ctx, dest, archivePath, objectKey, *enc)
+	} else {
+		err = UploadFile(ctx, dest, archivePath, objectKey, digest)
+	}
+	if err != nil {
+		err := fmt.Errorf("failed to upload archive %q to destination %q: %w", objectKey, destName, err)
+		slog.Error("Failed to upload archive to destination",
+			"archive_key", objectKey,
+			"destination", destName,
+			"error", err,
+			"job_name", job.Output.Name,
+		)
+		return objectKey, err
+	}
+
+	slog.Info("Successfully uploaded archive to destination",
+		"archive_key", objectKey,
+		"destination", destName,
+		"job_name", job.Output.Name,
+	)
+	return objectKey, nil
+}
+
+// uploadEncrypted encrypts the archive as it is streamed to the destination,
+// recording the encryption scheme in the object metadata.
+func uploadEncrypted(ctx context.Context, dest Destination, archivePath, objectKey string, enc models.EncryptionConfig) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file %q: %w", archivePath, err)
+	}
+	defer file.Close()
+
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := encryption.Encrypt(pw, enc)
+		if err == nil {
+			_, err = io.Copy(w, file)
+			if closeErr := w.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+
+	metadata := map[string]string{encryption.MetadataKey: encryption.Scheme(enc)}
+	err = uploadVerified(ctx, dest, pr, objectKey, metadata)
+	// Unblock the encrypting goroutine if the upload stopped reading early.
+	pr.CloseWithError(err)
+	return err
+}
+
+// abortStaleUploads cleans up incomplete uploads under the job's prefix left
+// on the destination by crashed runs, if the destination can have any.
+func abortStaleUploads(ctx context.Context, dest Destination, destName, jobPrefix string) {
+	aborter, ok := dest.(StaleUploadAborter)
+	if !ok {
+		return
+	}
+	if err := aborter.AbortStaleUploads(ctx, jobPrefix); err != nil {
+		slog.Warn("Failed to clean up stale incomplete uploads", "destination", destName, "error", err)
+	}
+}
+
+// UploadManifest uploads the manifest next to the archive on every
+// destination that received it, encrypted like the archive. A failure is
+// logged but does not fail the destination, since the archive itself ends
+// with the same manifest.
+func UploadManifest(ctx context.Context, cfg *models.Config, job models.JobConfig, results []models.DestinationResult, m *manifest.Manifest) {
+	data, err := m.Marshal()
+	if err != nil {
+		slog.Error("Failed to encode manifest, not uploading it", "job_name", job.Output.Name, "error", err)
+		return
+	}
+
+	for _, result := range results {
+		if result.Status != models.StatusSuccess {
+			continue
+		}
+		key := manifest.SidecarKey(result.ObjectKey)
+		if err := uploadManifest(ctx, cfg.Destinations[result.Name], job, key, data); err != nil {
+			slog.Warn("Failed to upload manifest next to archive", "destination", result.Name, "key", key, "error", err)
+			continue
+		}
+		slog.Info("Uploaded manifest next to archive", "destination", result.Name, "key", key)
+	}
+}
+
+// uploadManifest uploads the encoded manifest to a single destination.
+func uploadManifest(ctx context.Context, destConfig models.DestinationConfig, job models.JobConfig, key string, data []byte) error {
+	dest, err := New(ctx, destConfig)
+	if err != nil {
+		return err
+	}
+
+	enc := encryption.Effective(job, destConfig)
+	if enc == nil {
+		return uploadVerified(ctx, dest, bytes.NewReader(data), key, nil)
+	}
+
+	var encrypted bytes.Buffer
+	w, err := encryption.Encrypt(&encrypted, *enc)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	metadata := map[string]string{encryption.MetadataKey: encryption.Scheme(*enc)}
+	return uploadVerified(ctx, dest, &encrypted, key, metadata)
+}
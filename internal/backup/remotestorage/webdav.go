@@ -0,0 +1,626 @@
+package remotestorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+func init() {
+	Register("webdav", Provider{
+		New: func(ctx context.Context, cfg models.DestinationConfig) (Destination, error) {
+			return NewWebDAVDestination(cfg)
+		},
+		CheckConfig: func(cfg models.DestinationConfig) error {
+			if cfg.EndpointURL == "" {
+				return errors.New("missing endpointUrl")
+			}
+			return nil
+		},
+	})
+}
+
+// WebDAVDestination stores objects as files below a collection on a WebDAV
+// server such as Nextcloud or ownCloud.
+type WebDAVDestination struct {
+	client   *http.Client
+	base     *url.URL
+	user     string
+	password string
+	upload   uploadOptions
+	// uploads is the collection chunked uploads are made in, or nil when the
+	// base collection is not the files of a Nextcloud or ownCloud user.
+	uploads *url.URL
+}
+
+// NewWebDAVDestination prepares a WebDAV destination rooted at the endpoint
+// URL joined with the configured path.
+func NewWebDAVDestination(cfg models.DestinationConfig) (*WebDAVDestination, error) {
+	base, err := url.Parse(cfg.EndpointURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint URL %q: %w", cfg.EndpointURL, err)
+	}
+	base.Path = path.Join("/", base.Path, cfg.Path) + "/"
+	base.RawPath = ""
+
+	return &WebDAVDestination{
+		client:   &http.Client{},
+		base:     base,
+		user:     cfg.User,
+		password: cfg.Password,
+		upload:   newUploadOptions(cfg.Upload),
+		uploads:  chunkedUploadsURL(base),
+	}, nil
+}
+
+// davFilesPath is where Nextcloud and ownCloud serve the files of a user,
+// below a collection named after the user.
+const davFilesPath = "/remote.php/dav/files/"
+
+// chunkedUploadsURL returns the collection that chunked uploads to the files
+// of a Nextcloud or ownCloud user are made in, or nil if base is not below
+// the files of a user.
+func chunkedUploadsURL(base *url.URL) *url.URL {
+	root, rest, ok := strings.Cut(base.Path, davFilesPath)
+	if !ok {
+		return nil
+	}
+	user, _, ok := strings.Cut(rest, "/")
+	if !ok || user == "" {
+		return nil
+	}
+	u := *base
+	u.Path = root + "/remote.php/dav/uploads/" + user + "/"
+	return &u
+}
+
+// resourceURL returns the URL of a path relative to the base collection.
+// Collections are named with a trailing slash, or "" for the base itself.
+func (d *WebDAVDestination) resourceURL(name string) string {
+	u := *d.base
+	u.Path = path.Join(d.base.Path, name)
+	if name == "" || strings.HasSuffix(name, "/") {
+		u.Path += "/"
+	}
+	return u.String()
+}
+
+// collectionName returns how a collection named dir is passed to resourceURL.
+func collectionName(dir string) string {
+	if dir == "" || dir == "." {
+		return ""
+	}
+	return dir + "/"
+}
+
+// objectName validates an object key and returns it relative to the base
+// collection, rejecting keys that would escape it.
+func objectName(objectKey string) (string, error) {
+	name := path.Clean("/" + objectKey)
+	if name == "/" || strings.HasPrefix(path.Base(name), tempPrefix) || strings.HasSuffix(name, metadataExtension) {
+		return "", fmt.Errorf("invalid object key %q", objectKey)
+	}
+	return strings.TrimPrefix(name, "/"), nil
+}
+
+// do sends a request for a path relative to the base collection and fails
+// unless the response has one of the expected status codes. The caller must
+// close the body of the returned response.
+func (d *WebDAVDestination) do(ctx context.Context, method, name string, body io.Reader, header http.Header, expected ...int) (*http.Response, error) {
+	return d.doURL(ctx, method, d.resourceURL(name), body, header, expected...)
+}
+
+// doURL is like do, for any URL of the server.
+func (d *WebDAVDestination) doURL(ctx context.Context, method, rawURL string, body io.Reader, header http.Header, expected ...int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if d.user != "" || d.password != "" {
+		req.SetBasicAuth(d.user, d.password)
+	}
+	if sizer, ok := body.(interface{ Size() int64 }); ok {
+		req.ContentLength = sizer.Size()
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s failed: %w", method, req.URL.Redacted(), err)
+	}
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+
+	err = fmt.Errorf("%s %s failed: %s", method, req.URL.Redacted(), resp.Status)
+	if resp.StatusCode == http.StatusNotFound {
+		err = fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return nil, err
+}
+
+// Validate checks that the base collection exists and that the credentials
+// give access to it.
+func (d *WebDAVDestination) Validate(ctx context.Context) error {
+	entries, err := d.propfind(ctx, "", "0")
+	if err != nil {
+		return fmt.Errorf("failed to access WebDAV collection %q: %w", d.base.Redacted(), err)
+	}
+	if len(entries) == 0 || !entries[0].collection {
+		return fmt.Errorf("%q is not a WebDAV collection", d.base.Redacted())
+	}
+	return nil
+}
+
+// Upload stores everything read from r as objectKey. Streams larger than one
+// part are sent in chunks to Nextcloud and ownCloud servers (see putChunked),
+// holding one chunk in memory, with chunks growing as the stream does (see
+// streamPartSize). Other servers get a single PUT to a temporary resource
+// that is moved into place once complete, with HTTP chunked transfer
+// encoding when the stream is larger than one part.
+func (d *WebDAVDestination) Upload(ctx context.Context, r io.Reader, objectKey string, metadata map[string]string) error {
+	first := make([]byte, d.upload.partSize)
+	n, err := io.ReadFull(r, first)
+	switch err {
+	case io.EOF, io.ErrUnexpectedEOF:
+		// The whole stream fits in memory and can be sent with its length.
+		return d.put(ctx, objectKey, metadata, func(name string) error {
+			return d.putAtomic(ctx, bytes.NewReader(first[:n]), name)
+		})
+	case nil:
+	default:
+		return fmt.Errorf("failed to read upload stream for key %q: %w", objectKey, err)
+	}
+
+	next := func(number int32, buf *[]byte) (io.ReadSeeker, error) {
+		if number == 1 {
+			return bytes.NewReader(first), nil
+		}
+		size := d.upload.streamPartSize(number)
+		if int64(len(*buf)) < size {
+			*buf = make([]byte, size)
+		}
+		n, err := io.ReadFull(r, (*buf)[:size])
+		if n == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+			return nil, io.EOF
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("failed to read upload stream for key %q: %w", objectKey, err)
+		}
+		if number > d.upload.maxParts {
+			return nil, fmt.Errorf("upload stream for key %q is larger than %d chunks", objectKey, d.upload.maxParts)
+		}
+		return bytes.NewReader((*buf)[:n]), nil
+	}
+	return d.put(ctx, objectKey, metadata, func(name string) error {
+		if chunked, err := d.putChunked(ctx, name, -1, next); chunked || err != nil {
+			return err
+		}
+		return d.putAtomic(ctx, io.MultiReader(bytes.NewReader(first), r), name)
+	})
+}
+
+// UploadFile uploads a local file as objectKey, sending its length up front.
+// Files larger than one part are sent in chunks to Nextcloud and ownCloud
+// servers (see putChunked), and in a single PUT to others.
+func (d *WebDAVDestination) UploadFile(ctx context.Context, filePath, objectKey string, digest Digest) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file %q: %w", filePath, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file %q: %w", filePath, err)
+	}
+	size := info.Size()
+	partSize := d.upload.partSizeFor(size)
+	next := func(number int32, _ *[]byte) (io.ReadSeeker, error) {
+		offset := int64(number-1) * partSize
+		if offset >= size {
+			return nil, io.EOF
+		}
+		return io.NewSectionReader(file, offset, min(partSize, size-offset)), nil
+	}
+	return d.put(ctx, objectKey, nil, func(name string) error {
+		if size > d.upload.partSize {
+			if chunked, err := d.putChunked(ctx, name, size, next); chunked || err != nil {
+				return err
+			}
+		}
+		return d.putAtomic(ctx, io.NewSectionReader(file, 0, size), name)
+	})
+}
+
+// put writes the metadata of an object through a temporary resource, then
+// the object itself with write, passing it the name of the object.
+func (d *WebDAVDestination) put(ctx context.Context, objectKey string, metadata map[string]string, write func(name string) error) error {
+	name, err := objectName(objectKey)
+	if err != nil {
+		return err
+	}
+	if err := d.mkcolAll(ctx, path.Dir(name)); err != nil {
+		return err
+	}
+
+	if len(metadata) > 0 {
+		data, err := json.Marshal(metadata)
+		if err != nil {
+			return fmt.Errorf("failed to encode metadata of %q: %w", objectKey, err)
+		}
+		if err := d.putAtomic(ctx, bytes.NewReader(data), name+metadataExtension); err != nil {
+			return err
+		}
+	}
+
+	if err := write(name); err != nil {
+		return err
+	}
+	slog.Debug("Wrote object to WebDAV destination", "url", d.base.Redacted(), "key", name)
+	return nil
+}
+
+// putChunked uploads name with the chunked upload protocol (version 2) of
+// Nextcloud and ownCloud, which keeps each request below the body size limits
+// of proxies in front of the server. The chunks returned by next, until it
+// returns io.EOF, are uploaded one at a time into a new upload collection,
+// each retried on its own with exponential backoff, and the server then
+// assembles them into name in one step. size is the total length, or -1 if it
+// is not known. It reports false, without calling next, when the server does
+// not support chunked uploads.
+func (d *WebDAVDestination) putChunked(ctx context.Context, name string, size int64, next func(number int32, buf *[]byte) (io.ReadSeeker, error)) (chunked bool, err error) {
+	if d.uploads == nil {
+		return false, nil
+	}
+	folder := *d.uploads
+	folder.Path += "backup-companion-" + strconv.FormatInt(time.Now().UnixNano(), 36) + "/"
+	header := http.Header{"Destination": {d.resourceURL(name)}}
+	if size >= 0 {
+		header.Set("OC-Total-Length", strconv.FormatInt(size, 10))
+	}
+
+	resp, err := d.doURL(ctx, "MKCOL", folder.String(), nil, header,
+		http.StatusCreated, http.StatusForbidden, http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusConflict, http.StatusNotImplemented)
+	if err != nil {
+		return false, fmt.Errorf("failed to start chunked upload of %q: %w", name, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		slog.Debug("WebDAV server does not support chunked uploads", "url", d.uploads.Redacted(), "status", resp.Status)
+		return false, nil
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		// Don't leave the uploaded chunks behind, even when cancelled.
+		if resp, err := d.doURL(context.Background(), http.MethodDelete, folder.String(), nil, nil, http.StatusOK, http.StatusNoContent); err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	var buf []byte
+	for number := int32(1); ; number++ {
+		body, err := next(number, &buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return true, err
+		}
+		chunk := folder
+		chunk.Path += fmt.Sprintf("%05d", number)
+		if err := d.putChunk(ctx, chunk.String(), header, body, name, number); err != nil {
+			return true, err
+		}
+	}
+
+	header.Set("Overwrite", "T")
+	resp, err = d.doURL(ctx, "MOVE", folder.String()+".file", nil, header, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return true, fmt.Errorf("failed to assemble chunked upload of %q: %w", name, err)
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// putChunk uploads a chunk of a chunked upload, retrying with exponential
+// backoff.
+func (d *WebDAVDestination) putChunk(ctx context.Context, chunkURL string, header http.Header, body io.ReadSeeker, name string, number int32) error {
+	for attempt := 0; ; attempt++ {
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to rewind chunk %d of %q: %w", number, name, err)
+		}
+
+		resp, err := d.doURL(ctx, http.MethodPut, chunkURL, body, header, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+		if err == nil {
+			resp.Body.Close()
+			return nil
+		}
+		if attempt >= d.upload.maxRetries || ctx.Err() != nil {
+			return fmt.Errorf("failed to upload chunk %d of %q after %d attempts: %w", number, name, attempt+1, err)
+		}
+
+		delay := retryDelay(attempt)
+		slog.Warn("Retrying chunk upload", "key", name, "chunk", number, "attempt", attempt+1, "delay", delay, "error", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// putAtomic uploads to a temporary resource next to name and moves it over name.
+func (d *WebDAVDestination) putAtomic(ctx context.Context, r io.Reader, name string) (err error) {
+	dir, base := path.Split(name)
+	temp := path.Join(dir, tempPrefix+base+"-"+strconv.FormatInt(time.Now().UnixNano(), 36))
+	defer func() {
+		if err == nil {
+			return
+		}
+		// Don't leave a partial upload behind, even when cancelled.
+		if resp, err := d.do(context.Background(), http.MethodDelete, temp, nil, nil, http.StatusOK, http.StatusNoContent); err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	resp, err := d.do(ctx, http.MethodPut, temp, r, nil, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return fmt.Errorf("failed to upload %q: %w", name, err)
+	}
+	resp.Body.Close()
+
+	header := http.Header{
+		"Destination": {d.resourceURL(name)},
+		"Overwrite":   {"T"},
+	}
+	resp, err = d.do(ctx, "MOVE", temp, nil, header, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return fmt.Errorf("failed to move %q into place: %w", name, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// mkcolAll creates the collection dir and any missing parents.
+func (d *WebDAVDestination) mkcolAll(ctx context.Context, dir string) error {
+	if dir == "." || dir == "" {
+		return nil
+	}
+	if err := d.mkcolAll(ctx, path.Dir(dir)); err != nil {
+		return err
+	}
+
+	// 405 Method Not Allowed means the collection already exists.
+	resp, err := d.do(ctx, "MKCOL", collectionName(dir), nil, nil, http.StatusCreated, http.StatusMethodNotAllowed)
+	if err != nil {
+		return fmt.Errorf("failed to create collection %q: %w", dir, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List returns every object whose key starts with prefix.
+func (d *WebDAVDestination) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := d.walk(ctx, "", func(entry davEntry) {
+		base := path.Base(entry.name)
+		if strings.HasPrefix(base, tempPrefix) || strings.HasSuffix(base, metadataExtension) || !strings.HasPrefix(entry.name, prefix) {
+			return
+		}
+		objects = append(objects, ObjectInfo{Key: entry.name, Size: entry.size, LastModified: entry.modified})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list WebDAV collection %q: %w", d.base.Redacted(), err)
+	}
+	return objects, nil
+}
+
+// walk calls fn for every non-collection resource below the collection dir.
+// It lists one level at a time, since many servers refuse Depth: infinity.
+func (d *WebDAVDestination) walk(ctx context.Context, dir string, fn func(davEntry)) error {
+	entries, err := d.propfind(ctx, collectionName(dir), "1")
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.name == dir {
+			continue // The collection itself
+		}
+		if entry.collection {
+			if err := d.walk(ctx, entry.name, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		fn(entry)
+	}
+	return nil
+}
+
+// Download opens an object for reading.
+func (d *WebDAVDestination) Download(ctx context.Context, objectKey string) (io.ReadCloser, error) {
+	name, err := objectName(objectKey)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.do(ctx, http.MethodGet, name, nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download object %q: %w", objectKey, err)
+	}
+	return resp.Body, nil
+}
+
+// Delete removes an object and its metadata.
+func (d *WebDAVDestination) Delete(ctx context.Context, objectKey string) error {
+	name, err := objectName(objectKey)
+	if err != nil {
+		return err
+	}
+	resp, err := d.do(ctx, http.MethodDelete, name, nil, nil, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return fmt.Errorf("failed to delete object %q: %w", objectKey, err)
+	}
+	resp.Body.Close()
+
+	resp, err = d.do(ctx, http.MethodDelete, name+metadataExtension, nil, nil, http.StatusOK, http.StatusNoContent)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to delete metadata of object %q: %w", objectKey, err)
+	}
+	if err == nil {
+		resp.Body.Close()
+	}
+	return nil
+}
+
+// Stat describes an object, including its metadata.
+func (d *WebDAVDestination) Stat(ctx context.Context, objectKey string) (ObjectInfo, error) {
+	name, err := objectName(objectKey)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	entries, err := d.propfind(ctx, name, "0")
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to stat object %q: %w", objectKey, err)
+	}
+	if len(entries) == 0 || entries[0].collection {
+		return ObjectInfo{}, fmt.Errorf("failed to stat object %q: %w", objectKey, ErrNotFound)
+	}
+	object := ObjectInfo{Key: objectKey, Size: entries[0].size, LastModified: entries[0].modified}
+
+	resp, err := d.do(ctx, http.MethodGet, name+metadataExtension, nil, nil, http.StatusOK)
+	if err == nil {
+		err = json.NewDecoder(resp.Body).Decode(&object.Metadata)
+		resp.Body.Close()
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return ObjectInfo{}, fmt.Errorf("failed to read metadata of object %q: %w", objectKey, err)
+	}
+	return object, nil
+}
+
+// AbortStaleUploads removes the temporary resources of objects starting with
+// prefix that were last written longer ago than the destination's
+// abortIncompleteAfter, such as those left behind by crashed runs.
+func (d *WebDAVDestination) AbortStaleUploads(ctx context.Context, prefix string) error {
+	cutoff := time.Now().Add(-d.upload.abortIncompleteAfter)
+
+	dirPrefix, namePrefix := splitPrefix(prefix)
+	dir := strings.TrimPrefix(path.Clean("/"+dirPrefix), "/")
+	entries, err := d.propfind(ctx, collectionName(dir), "1")
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	var removeErrors []error
+	for _, entry := range entries {
+		if entry.collection || !strings.HasPrefix(path.Base(entry.name), tempPrefix+namePrefix) || entry.modified.After(cutoff) {
+			continue
+		}
+		slog.Info("Removing stale incomplete upload", "url", d.base.Redacted(), "key", entry.name, "modified", entry.modified)
+		resp, err := d.do(ctx, http.MethodDelete, entry.name, nil, nil, http.StatusOK, http.StatusNoContent)
+		if err != nil {
+			removeErrors = append(removeErrors, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+	return errors.Join(removeErrors...)
+}
+
+// davEntry is a resource listed by PROPFIND, named relative to the base
+// collection.
+type davEntry struct {
+	name       string
+	size       int64
+	modified   time.Time
+	collection bool
+}
+
+// multistatus is the subset of a PROPFIND response that is used.
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Status string `xml:"status"`
+			Prop   struct {
+				ContentLength string `xml:"getcontentlength"`
+				LastModified  string `xml:"getlastmodified"`
+				ResourceType  struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:getcontentlength/><d:getlastmodified/><d:resourcetype/></d:prop></d:propfind>`
+
+// propfind lists the resource name, and its members if depth is "1".
+func (d *WebDAVDestination) propfind(ctx context.Context, name, depth string) ([]davEntry, error) {
+	header := http.Header{
+		"Depth":        {depth},
+		"Content-Type": {"application/xml; charset=utf-8"},
+	}
+	resp, err := d.do(ctx, "PROPFIND", name, strings.NewReader(propfindBody), header, http.StatusMultiStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("failed to parse PROPFIND response: %w", err)
+	}
+
+	entries := make([]davEntry, 0, len(ms.Responses))
+	for _, response := range ms.Responses {
+		href, err := url.Parse(response.Href)
+		if err != nil {
+			return nil, fmt.Errorf("invalid href %q in PROPFIND response: %w", response.Href, err)
+		}
+		entry := davEntry{
+			name: strings.Trim(strings.TrimPrefix(path.Clean("/"+href.Path), strings.TrimSuffix(d.base.Path, "/")), "/"),
+		}
+		for _, propstat := range response.Propstat {
+			if !strings.Contains(propstat.Status, " 200 ") {
+				continue
+			}
+			prop := propstat.Prop
+			entry.collection = entry.collection || prop.ResourceType.Collection != nil
+			if size, err := strconv.ParseInt(prop.ContentLength, 10, 64); err == nil {
+				entry.size = size
+			}
+			if modified, err := http.ParseTime(prop.LastModified); err == nil {
+				entry.modified = modified
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
@@ -0,0 +1,400 @@
+package remotestorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tderick/backup-companion-go/internal/models"
+	"golang.org/x/net/webdav"
+)
+
+// davRequest is a request received by the test WebDAV server.
+type davRequest struct {
+	method      string
+	path        string
+	destination string
+	chunked     bool
+	size        int64
+}
+
+// davServer is an in-memory WebDAV server requiring basic authentication
+// that records the requests it receives.
+type davServer struct {
+	handler  http.Handler
+	mu       sync.Mutex
+	requests []davRequest
+
+	// fs and prefix are set for servers that assemble chunked uploads like
+	// Nextcloud, which reject the next failChunks chunks they receive.
+	fs         webdav.FileSystem
+	prefix     string
+	failChunks int
+}
+
+func (s *davServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, password, ok := r.BasicAuth(); !ok || user != "backup" || password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, davRequest{
+		method:      r.Method,
+		path:        r.URL.Path,
+		destination: r.Header.Get("Destination"),
+		chunked:     len(r.TransferEncoding) > 0 && r.TransferEncoding[0] == "chunked",
+		size:        r.ContentLength,
+	})
+	fail := s.fs != nil && r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/uploads/") && s.failChunks > 0
+	if fail {
+		s.failChunks--
+	}
+	s.mu.Unlock()
+
+	switch {
+	case fail:
+		w.WriteHeader(http.StatusServiceUnavailable)
+	case s.fs != nil && r.Method == "MOVE" && strings.HasSuffix(r.URL.Path, "/.file"):
+		if err := s.assemble(r); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	default:
+		s.handler.ServeHTTP(w, r)
+	}
+}
+
+// assemble writes the chunks of a chunked upload, in the order of their
+// names, to its destination and removes the upload collection.
+func (s *davServer) assemble(r *http.Request) error {
+	ctx := r.Context()
+	folder := strings.TrimPrefix(strings.TrimSuffix(r.URL.Path, ".file"), s.prefix)
+	destination, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil {
+		return err
+	}
+
+	dir, err := s.fs.OpenFile(ctx, folder, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	chunks, err := dir.Readdir(-1)
+	dir.Close()
+	if err != nil {
+		return err
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Name() < chunks[j].Name() })
+
+	out, err := s.fs.OpenFile(ctx, strings.TrimPrefix(destination.Path, s.prefix), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	for _, chunk := range chunks {
+		in, err := s.fs.OpenFile(ctx, path.Join(folder, chunk.Name()), os.O_RDONLY, 0)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return s.fs.RemoveAll(ctx, folder)
+}
+
+// take returns the requests received since it was last called.
+func (s *davServer) take() []davRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := s.requests
+	s.requests = nil
+	return requests
+}
+
+// newTestWebDAVServer starts a WebDAV server with an empty "/dav/backups"
+// collection.
+func newTestWebDAVServer(t *testing.T) (*davServer, *httptest.Server) {
+	t.Helper()
+	fs := webdav.NewMemFS()
+	if err := fs.Mkdir(context.Background(), "/backups", 0755); err != nil {
+		t.Fatal(err)
+	}
+	dav := &davServer{handler: &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: fs,
+		LockSystem: webdav.NewMemLS(),
+	}}
+	server := httptest.NewServer(dav)
+	t.Cleanup(server.Close)
+	return dav, server
+}
+
+// newTestNextcloudServer starts a WebDAV server laid out like Nextcloud, with
+// an empty "backups" collection in the files of the user "backup". Chunked
+// uploads are supported unless chunking is false.
+func newTestNextcloudServer(t *testing.T, chunking bool) (*davServer, *httptest.Server) {
+	t.Helper()
+	ctx := context.Background()
+	fs := webdav.NewMemFS()
+	dirs := []string{"/files", "/files/backup", "/files/backup/backups"}
+	if chunking {
+		dirs = append(dirs, "/uploads", "/uploads/backup")
+	}
+	for _, dir := range dirs {
+		if err := fs.Mkdir(ctx, dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dav := &davServer{
+		handler: &webdav.Handler{
+			Prefix:     "/remote.php/dav",
+			FileSystem: fs,
+			LockSystem: webdav.NewMemLS(),
+		},
+		fs:     fs,
+		prefix: "/remote.php/dav",
+	}
+	server := httptest.NewServer(dav)
+	t.Cleanup(server.Close)
+	return dav, server
+}
+
+func newTestWebDAVDestination(t *testing.T, endpoint, password string) *WebDAVDestination {
+	t.Helper()
+	dest, err := NewWebDAVDestination(models.DestinationConfig{
+		Provider:    "webdav",
+		EndpointURL: endpoint,
+		Path:        "backups",
+		User:        "backup",
+		Password:    password,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dest
+}
+
+func TestWebDAVDestinationValidate(t *testing.T) {
+	_, server := newTestWebDAVServer(t)
+	tests := []struct {
+		name     string
+		endpoint string
+		password string
+		wantErr  bool
+	}{
+		{"valid", server.URL + "/dav", "secret", false},
+		{"wrong password", server.URL + "/dav", "wrong", true},
+		{"missing collection", server.URL + "/dav/missing", "secret", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := newTestWebDAVDestination(t, tt.endpoint, tt.password)
+			if err := dest.Validate(context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWebDAVDestination(t *testing.T) {
+	ctx := context.Background()
+	dav, server := newTestWebDAVServer(t)
+	dest := newTestWebDAVDestination(t, server.URL+"/dav", "secret")
+
+	// Upload sends the object to a temporary resource and moves it into place
+	data := []byte("archive contents")
+	if err := dest.Upload(ctx, bytes.NewReader(data), "host/backup.tar.gz", map[string]string{"sha256": "abc"}); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	var put, move *davRequest
+	for _, req := range dav.take() {
+		if strings.HasSuffix(req.path, metadataExtension) || strings.HasSuffix(req.destination, metadataExtension) {
+			continue
+		}
+		switch req.method {
+		case http.MethodPut:
+			put = &req
+		case "MOVE":
+			move = &req
+		}
+	}
+	if put == nil || !strings.HasPrefix(put.path, "/dav/backups/host/"+tempPrefix+"backup.tar.gz-") {
+		t.Fatalf("PUT = %+v, want a temporary resource next to the object", put)
+	}
+	if move == nil || move.path != put.path || !strings.HasSuffix(move.destination, "/dav/backups/host/backup.tar.gz") {
+		t.Fatalf("MOVE = %+v, want the temporary resource moved to the object", move)
+	}
+
+	// Streams larger than a part are sent with chunked transfer encoding
+	dest.upload.partSize = 4
+	if err := dest.Upload(ctx, io.MultiReader(strings.NewReader("larger than"), strings.NewReader(" a part")), "other.tar.gz", nil); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	var puts int
+	for _, req := range dav.take() {
+		if req.method != http.MethodPut {
+			continue
+		}
+		puts++
+		if !req.chunked {
+			t.Errorf("PUT %s was not chunked", req.path)
+		}
+	}
+	if puts != 1 {
+		t.Errorf("got %d PUT requests, want 1", puts)
+	}
+
+	objects, err := dest.List(ctx, "")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	var keys []string
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "host/backup.tar.gz,other.tar.gz" {
+		t.Errorf("List() keys = %v, want host/backup.tar.gz and other.tar.gz", keys)
+	}
+	if objects, err := dest.List(ctx, "host/"); err != nil || len(objects) != 1 || objects[0].Size != int64(len(data)) {
+		t.Errorf("List(host/) = %+v, %v, want host/backup.tar.gz of %d bytes", objects, err, len(data))
+	}
+
+	body, err := dest.Download(ctx, "other.tar.gz")
+	if err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	got, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || string(got) != "larger than a part" {
+		t.Errorf("Download() = %q, %v, want %q", got, err, "larger than a part")
+	}
+
+	info, err := dest.Stat(ctx, "host/backup.tar.gz")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Size != int64(len(data)) || info.Metadata["sha256"] != "abc" {
+		t.Errorf("Stat() = %+v, want size %d and the uploaded metadata", info, len(data))
+	}
+
+	if err := dest.Delete(ctx, "host/backup.tar.gz"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if objects, err := dest.List(ctx, "host/"); err != nil || len(objects) != 0 {
+		t.Errorf("List(host/) after Delete() = %+v, %v, want nothing", objects, err)
+	}
+
+	// Missing objects are reported as ErrNotFound
+	if _, err := dest.Download(ctx, "host/backup.tar.gz"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Download() of a deleted object error = %v, want ErrNotFound", err)
+	}
+	if _, err := dest.Stat(ctx, "host/backup.tar.gz"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat() of a deleted object error = %v, want ErrNotFound", err)
+	}
+	if err := dest.Delete(ctx, "host/backup.tar.gz"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete() of a deleted object error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestWebDAVDestinationChunkedUpload(t *testing.T) {
+	const data = "0123456789abcdefghijklmnopqrstuvwxyz"
+	uploadFile := func(t *testing.T, dest *WebDAVDestination) error {
+		file := filepath.Join(t.TempDir(), "backup.tar.gz")
+		if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return dest.UploadFile(context.Background(), file, "host/backup.tar.gz", digestOf(data))
+	}
+	upload := func(t *testing.T, dest *WebDAVDestination) error {
+		// A reader without a length, like an archive being streamed
+		r := io.MultiReader(strings.NewReader(data[:10]), strings.NewReader(data[10:]))
+		return dest.Upload(context.Background(), r, "host/backup.tar.gz", map[string]string{"sha256": "abc"})
+	}
+
+	tests := []struct {
+		name       string
+		chunking   bool
+		failChunks int
+		upload     func(*testing.T, *WebDAVDestination) error
+		// wantChunks are the sizes of the chunks uploaded, none for a
+		// single PUT.
+		wantChunks []int64
+	}{
+		{"file", true, 0, uploadFile, []int64{8, 8, 8, 8, 4}},
+		{"file with a failing chunk", true, 1, uploadFile, []int64{8, 8, 8, 8, 8, 4}},
+		// Stream chunks double every two chunks
+		{"stream", true, 0, upload, []int64{8, 8, 16, 4}},
+		{"server without chunked uploads", false, 0, uploadFile, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dav, server := newTestNextcloudServer(t, tt.chunking)
+			dav.failChunks = tt.failChunks
+			dest := newTestWebDAVDestination(t, server.URL+"/remote.php/dav/files/backup", "secret")
+			dest.upload.partSize = 8
+			dest.upload.maxParts = 20
+
+			if err := tt.upload(t, dest); err != nil {
+				t.Fatalf("upload error = %v", err)
+			}
+
+			var chunks []int64
+			var puts int
+			for _, req := range dav.take() {
+				if req.method != http.MethodPut || strings.Contains(req.path, metadataExtension) {
+					continue
+				}
+				if strings.HasPrefix(req.path, "/remote.php/dav/uploads/backup/") {
+					chunks = append(chunks, req.size)
+					if !strings.HasSuffix(req.destination, "/remote.php/dav/files/backup/backups/host/backup.tar.gz") {
+						t.Errorf("chunk %s has destination %q, want the object", req.path, req.destination)
+					}
+				} else {
+					puts++
+				}
+			}
+			if !slices.Equal(chunks, tt.wantChunks) {
+				t.Errorf("chunk sizes = %v, want %v", chunks, tt.wantChunks)
+			}
+			if wantPuts := map[bool]int{true: 0, false: 1}[len(tt.wantChunks) > 0]; puts != wantPuts {
+				t.Errorf("got %d single PUT requests, want %d", puts, wantPuts)
+			}
+
+			body, err := dest.Download(context.Background(), "host/backup.tar.gz")
+			if err != nil {
+				t.Fatalf("Download() error = %v", err)
+			}
+			got, err := io.ReadAll(body)
+			body.Close()
+			if err != nil || string(got) != data {
+				t.Errorf("Download() = %q, %v, want %q", got, err, data)
+			}
+			if tt.chunking {
+				dir, err := dav.fs.OpenFile(context.Background(), "/uploads/backup", os.O_RDONLY, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer dir.Close()
+				if left, err := dir.Readdir(-1); err != nil || len(left) != 0 {
+					t.Errorf("%d upload collections left behind, %v", len(left), err)
+				}
+			}
+		})
+	}
+}
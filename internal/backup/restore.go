@@ -0,0 +1,231 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/tderick/backup-companion-go/internal/backup/database"
+	"github.com/tderick/backup-companion-go/internal/backup/encryption"
+	"github.com/tderick/backup-companion-go/internal/backup/objectkey"
+	"github.com/tderick/backup-companion-go/internal/backup/remotestorage"
+	"github.com/tderick/backup-companion-go/internal/backup/util"
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+// RestoreOptions selects the backup to restore and where to restore it.
+type RestoreOptions struct {
+	Job string
+	// Destination to download from; defaults to the job's first destination.
+	Destination string
+	// Snapshot is the object key or archive name of the backup to restore.
+	// When empty the latest backup is used.
+	Snapshot string
+	// TargetDir is where the archive is unpacked.
+	TargetDir string
+	// IntoDatabase, when set, names a database source to reload from the
+	// dump found in the archive.
+	IntoDatabase string
+}
+
+// Restore downloads a backup of a job, unpacks it into the target directory
+// and optionally reloads one of its database dumps.
+func Restore(ctx context.Context, cfg *models.Config, opts RestoreOptions) error {
+	job, ok := cfg.Jobs[opts.Job]
+	if !ok {
+		return fmt.Errorf("unknown job %q", opts.Job)
+	}
+
+	destName := opts.Destination
+	if destName == "" {
+		destName = job.Destinations[0]
+	}
+	destConfig, ok := cfg.Destinations[destName]
+	if !ok {
+		return fmt.Errorf("unknown destination %q", destName)
+	}
+
+	var dbConfig models.DatabaseConfig
+	if opts.IntoDatabase != "" {
+		if dbConfig, ok = cfg.Sources.Databases[opts.IntoDatabase]; !ok {
+			return fmt.Errorf("unknown database source %q", opts.IntoDatabase)
+		}
+	}
+
+	dest, err := remotestorage.New(ctx, destConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create client for destination %q: %w", destName, err)
+	}
+
+	layout, err := objectkey.New(opts.Job, job, destConfig)
+	if err != nil {
+		return err
+	}
+	objectKey, err := resolveSnapshot(ctx, dest, layout, opts.Snapshot)
+	if err != nil {
+		return fmt.Errorf("destination %q: %w", destName, err)
+	}
+
+	if err := os.MkdirAll(opts.TargetDir, 0755); err != nil {
+		return fmt.Errorf("failed to create target directory %q: %w", opts.TargetDir, err)
+	}
+	if err := os.MkdirAll(job.Output.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory %q: %w", job.Output.Dir, err)
+	}
+
+	// Download to a file of its own: the output directory may still hold
+	// the archive under its own name, e.g. while its upload is pending.
+	file, err := os.CreateTemp(job.Output.Dir, ".restore-*")
+	if err != nil {
+		return fmt.Errorf("failed to create download file in %q: %w", job.Output.Dir, err)
+	}
+	file.Close()
+	archivePath := file.Name()
+	defer func() {
+		if err := os.Remove(archivePath); err != nil && !os.IsNotExist(err) {
+			slog.Error("Failed to cleanup downloaded archive", "archivePath", archivePath, "error", err)
+		}
+	}()
+
+	slog.Info("Downloading backup", "job_name", opts.Job, "destination", destName, "key", objectKey)
+	if err := remotestorage.DownloadFile(ctx, dest, objectKey, archivePath); err != nil {
+		return err
+	}
+
+	if err := extractArchive(archivePath, objectKey, opts.TargetDir, encryption.Effective(job, destConfig)); err != nil {
+		return err
+	}
+	slog.Info("Backup restored", "job_name", opts.Job, "key", objectKey, "target_dir", opts.TargetDir)
+
+	if opts.IntoDatabase == "" {
+		return nil
+	}
+
+	dumpPath, err := findDump(opts.TargetDir, opts.IntoDatabase, dbConfig)
+	if err != nil {
+		return err
+	}
+	return database.RestoreDatabase(ctx, dbConfig, dumpPath)
+}
+
+// extractArchive unpacks a downloaded archive, decrypting it first if its
+// object key says it is encrypted.
+func extractArchive(archivePath, objectKey, targetDir string, enc *models.EncryptionConfig) error {
+	if !encryption.IsEncrypted(objectKey) {
+		return util.ExtractArchive(archivePath, targetDir)
+	}
+	if enc == nil {
+		return fmt.Errorf("archive %q is encrypted but the job and destination have no encryption settings", objectKey)
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive file %q: %w", archivePath, err)
+	}
+	defer file.Close()
+
+	r, err := encryption.Decrypt(file, *enc)
+	if err != nil {
+		return fmt.Errorf("archive %q: %w", objectKey, err)
+	}
+	return util.ExtractArchiveReader(r, archivePath, targetDir)
+}
+
+// resolveSnapshot returns the object key of the named snapshot of a job on
+// a destination, or of its most recent archive when snapshot is empty. A
+// snapshot that is not among the job's archives is taken as an object key,
+// e.g. for backups stored under an earlier prefix.
+func resolveSnapshot(ctx context.Context, dest remotestorage.Destination, layout *objectkey.Layout, snapshot string) (string, error) {
+	archives, err := listJobArchives(ctx, dest, layout)
+	if err != nil {
+		return "", err
+	}
+
+	if snapshot != "" {
+		if archive, ok := findArchive(archives, snapshot); ok {
+			return archive.Key, nil
+		}
+		return snapshot, nil
+	}
+
+	if len(archives) == 0 {
+		return "", errors.New("no backup found")
+	}
+	latest := archives[0]
+	for _, archive := range archives[1:] {
+		if archive.Time.After(latest.Time) {
+			latest = archive
+		}
+	}
+	return latest.Key, nil
+}
+
+// findArchive returns the archive a snapshot refers to. A snapshot with a
+// slash is an object key; otherwise it is an archive name, which matches
+// whatever prefix the archive is stored under. Either matches with or without
+// the encryption extension, so that a snapshot can be named the same way on
+// every destination.
+func findArchive(archives []archiveObject, snapshot string) (archiveObject, bool) {
+	name := func(key string) string { return strings.TrimSuffix(key, encryption.Extension) }
+	if !strings.Contains(snapshot, "/") {
+		name = func(key string) string { return strings.TrimSuffix(path.Base(key), encryption.Extension) }
+	}
+
+	for _, archive := range archives {
+		if name(archive.Key) == name(snapshot) {
+			return archive, true
+		}
+	}
+	return archiveObject{}, false
+}
+
+// findDump locates the dump of a database source within an unpacked archive.
+// Archives made before sources were stored below their own directory are
+// searched as a whole.
+func findDump(targetDir, source string, db models.DatabaseConfig) (string, error) {
+	dir := filepath.Join(targetDir, util.DatabasesDir, source)
+	if _, err := os.Stat(dir); err != nil {
+		dir = targetDir
+	}
+
+	extensions := []string{".pgdump"}
+	if db.Driver == "mysql" {
+		extensions = []string{".sql", ".sql.gz"}
+	}
+
+	var matches []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if d.IsDir() || !strings.HasPrefix(name, db.Name+"_") {
+			return nil
+		}
+		for _, extension := range extensions {
+			if strings.HasSuffix(name, extension) {
+				matches = append(matches, path)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to search %q for database dumps: %w", dir, err)
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no %s dump of database %q found in the backup", strings.Join(extensions, " or "), db.Name)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("several dumps of database %q found in the backup: %s", db.Name, strings.Join(matches, ", "))
+	}
+}
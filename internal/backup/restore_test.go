@@ -0,0 +1,60 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tderick/backup-companion-go/internal/backup/util"
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+func TestRestoreKeepsLocalArchive(t *testing.T) {
+	const key = "app-2024-01-02-03-04-05.tar.gz"
+	source := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(source, util.FilesDir, "app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(source, util.FilesDir, "app", "hello.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	storage := t.TempDir()
+	if err := util.CreateArchive(source, filepath.Join(storage, key), models.CompressionConfig{}, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The archive is still in the output directory, e.g. waiting for an
+	// upload to be resumed.
+	outputDir := t.TempDir()
+	localArchive := filepath.Join(outputDir, key)
+	if err := os.WriteFile(localArchive, []byte("pending upload"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &models.Config{
+		Destinations: map[string]models.DestinationConfig{"nas": {Provider: "local", Path: storage}},
+		Jobs: map[string]models.JobConfig{"app": {
+			Output:       models.OutputConfig{Dir: outputDir, Name: "app"},
+			Destinations: []string{"nas"},
+		}},
+	}
+	target := t.TempDir()
+	if err := Restore(context.Background(), cfg, RestoreOptions{Job: "app", Snapshot: key, TargetDir: target}); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(target, util.FilesDir, "app", "hello.txt")); err != nil || string(data) != "hello" {
+		t.Errorf("restored hello.txt = %q, %v, want %q", data, err, "hello")
+	}
+	if data, err := os.ReadFile(localArchive); err != nil || string(data) != "pending upload" {
+		t.Errorf("local archive = %q, %v, want it untouched", data, err)
+	}
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("output directory holds %d files after Restore(), want only the local archive", len(entries))
+	}
+}
@@ -0,0 +1,89 @@
+package retention
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+// Snapshot is a remote backup considered for pruning.
+type Snapshot struct {
+	Key  string
+	Time time.Time
+}
+
+// Apply splits snapshots into those the policy keeps and those it removes.
+// Both lists are returned newest first.
+func Apply(policy models.RetentionConfig, snapshots []Snapshot, now time.Time) (keep, remove []Snapshot) {
+	sorted := make([]Snapshot, len(snapshots))
+	copy(sorted, snapshots)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.After(sorted[j].Time) })
+
+	hasKeepRules := policy.KeepLast > 0 || policy.KeepDaily > 0 || policy.KeepWeekly > 0 ||
+		policy.KeepMonthly > 0 || policy.KeepYearly > 0
+
+	buckets := []*bucket{
+		{limit: policy.KeepDaily, period: func(t time.Time) string { return t.Format("2006-01-02") }},
+		{limit: policy.KeepWeekly, period: func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{limit: policy.KeepMonthly, period: func(t time.Time) string { return t.Format("2006-01") }},
+		{limit: policy.KeepYearly, period: func(t time.Time) string { return t.Format("2006") }},
+	}
+
+	for i, snapshot := range sorted {
+		kept := !hasKeepRules || i < policy.KeepLast
+		for _, b := range buckets {
+			if b.keeps(snapshot.Time) {
+				kept = true
+			}
+		}
+		if policy.MaxAge > 0 && now.Sub(snapshot.Time) > policy.MaxAge {
+			kept = false
+		}
+
+		// Never prune the most recent backup, whatever the policy says.
+		if kept || i == 0 {
+			keep = append(keep, snapshot)
+		} else {
+			remove = append(remove, snapshot)
+		}
+	}
+	return keep, remove
+}
+
+// bucket keeps the newest snapshot of each of the last limit periods.
+type bucket struct {
+	limit  int
+	period func(time.Time) string
+	last   string
+	count  int
+}
+
+// keeps reports whether t is the newest snapshot of a period still within the
+// limit. Snapshots must be passed newest first.
+func (b *bucket) keeps(t time.Time) bool {
+	if b.count >= b.limit {
+		return false
+	}
+	period := b.period(t)
+	if period == b.last {
+		return false
+	}
+	b.last = period
+	b.count++
+	return true
+}
+
+// Effective returns the policy that applies to a job on a destination: the
+// destination's own policy if it has one, otherwise the job's. It returns nil
+// when neither defines a policy.
+func Effective(job models.JobConfig, dest models.DestinationConfig) *models.RetentionConfig {
+	if dest.Retention != nil {
+		return dest.Retention
+	}
+	return job.Retention
+}
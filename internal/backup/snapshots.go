@@ -0,0 +1,123 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tderick/backup-companion-go/internal/backup/encryption"
+	"github.com/tderick/backup-companion-go/internal/backup/objectkey"
+	"github.com/tderick/backup-companion-go/internal/backup/remotestorage"
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+// Snapshot is a backup of a job, together with the destinations holding a copy of it.
+type Snapshot struct {
+	Job string `json:"job"`
+	// Name identifies the snapshot across destinations; it is the archive
+	// name, without its prefix or any encryption extension.
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	// Copies lists the destinations the snapshot was found on.
+	Copies []SnapshotCopy `json:"copies"`
+	// Missing lists the job's destinations the snapshot was not found on.
+	// Destinations that could not be listed are not reported as missing.
+	Missing []string `json:"missing,omitempty"`
+}
+
+// SnapshotCopy is a copy of a snapshot stored on a destination.
+type SnapshotCopy struct {
+	Destination  string    `json:"destination"`
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// ListSnapshots lists the backups of the named jobs on all of their
+// destinations, oldest first. Destinations that cannot be listed are
+// reported in the returned error; the snapshots found elsewhere are still
+// returned.
+func ListSnapshots(ctx context.Context, cfg *models.Config, jobNames []string) ([]Snapshot, error) {
+	var snapshots []Snapshot
+	var listErrors []error
+	for _, jobName := range jobNames {
+		job := cfg.Jobs[jobName]
+
+		byKey := make(map[string]*Snapshot)
+		var listed []string
+		for _, destName := range job.Destinations {
+			archives, err := listDestinationArchives(ctx, cfg, jobName, job, destName)
+			if err != nil {
+				slog.Error("Failed to list backups on destination", "job_name", jobName, "destination", destName, "error", err)
+				listErrors = append(listErrors, fmt.Errorf("job %q, destination %q: %w", jobName, destName, err))
+				continue
+			}
+			listed = append(listed, destName)
+
+			for _, archive := range archives {
+				name := strings.TrimSuffix(path.Base(archive.Key), encryption.Extension)
+				snapshot, ok := byKey[name]
+				if !ok {
+					snapshot = &Snapshot{Job: jobName, Name: name, Time: archive.Time}
+					byKey[name] = snapshot
+				}
+				snapshot.Copies = append(snapshot.Copies, SnapshotCopy{
+					Destination:  destName,
+					Key:          archive.Key,
+					Size:         archive.Size,
+					LastModified: archive.LastModified,
+				})
+			}
+		}
+
+		jobSnapshots := make([]Snapshot, 0, len(byKey))
+		for _, snapshot := range byKey {
+			snapshot.Missing = missingCopies(listed, snapshot.Copies)
+			jobSnapshots = append(jobSnapshots, *snapshot)
+		}
+		sort.Slice(jobSnapshots, func(i, j int) bool { return jobSnapshots[i].Time.Before(jobSnapshots[j].Time) })
+		snapshots = append(snapshots, jobSnapshots...)
+	}
+	return snapshots, errors.Join(listErrors...)
+}
+
+// listDestinationArchives lists the archives of a job on the named destination.
+func listDestinationArchives(ctx context.Context, cfg *models.Config, jobName string, job models.JobConfig, destName string) ([]archiveObject, error) {
+	destConfig, ok := cfg.Destinations[destName]
+	if !ok {
+		return nil, fmt.Errorf("destination %q not found in config", destName)
+	}
+
+	dest, err := remotestorage.New(ctx, destConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create destination client: %w", err)
+	}
+	layout, err := objectkey.New(jobName, job, destConfig)
+	if err != nil {
+		return nil, err
+	}
+	return listJobArchives(ctx, dest, layout)
+}
+
+// missingCopies returns the destinations in listed that hold none of the copies.
+func missingCopies(listed []string, copies []SnapshotCopy) []string {
+	var missing []string
+	for _, destName := range listed {
+		found := false
+		for _, c := range copies {
+			if c.Destination == destName {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, destName)
+		}
+	}
+	return missing
+}
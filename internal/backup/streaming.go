@@ -0,0 +1,154 @@
+package backup
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/tderick/backup-companion-go/internal/backup/compression"
+	"github.com/tderick/backup-companion-go/internal/backup/database"
+	"github.com/tderick/backup-companion-go/internal/backup/filter"
+	"github.com/tderick/backup-companion-go/internal/backup/manifest"
+	"github.com/tderick/backup-companion-go/internal/backup/remotestorage"
+	"github.com/tderick/backup-companion-go/internal/backup/util"
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+// streamJob backs up a job without staging copies: directories are read
+// straight into a compressed tar stream that is uploaded to every destination
+// as it is produced. Database dumps still need their size up front for the tar
+// header, so each one is spooled to the output directory and removed once it
+// has been added; disk usage is bounded by the largest single dump.
+func streamJob(ctx context.Context, cfg *models.Config, jobName string, job models.JobConfig, result *models.JobResult) {
+	if err := os.MkdirAll(job.Output.Dir, 0755); err != nil {
+		result.Fail(fmt.Errorf("error creating output directory: %w", err))
+		return
+	}
+
+	archiveName := util.BackupName(job.Output, time.Now()) + util.ArchiveExtension(job.Output.Compression)
+	slog.Info("Streaming archive", "jobName", jobName, "archive_name", archiveName)
+
+	m := manifest.New(jobName, result.StartedAt)
+	archiveKey := archiveKeys(cfg, jobName, job, archiveName)
+	destinations, size, err := remotestorage.StreamArchiveToDestinations(ctx, cfg, job, archiveKey, func(w io.Writer) error {
+		compressor, err := compression.NewWriter(w, job.Output.Compression)
+		if err != nil {
+			return err
+		}
+		defer compressor.Close()
+		tarWriter := tar.NewWriter(compressor)
+
+		result.Sources = streamSources(ctx, cfg, job, tarWriter, m)
+		if !anySourceSucceeded(result.Sources) {
+			return errors.New("every source of the job failed, nothing to archive")
+		}
+
+		m.Finish(result.Sources)
+		if err := m.WriteTo(tarWriter); err != nil {
+			return err
+		}
+		if err := tarWriter.Close(); err != nil {
+			return fmt.Errorf("failed to finish tar stream: %w", err)
+		}
+		return compressor.Close()
+	})
+	result.Destinations = destinations
+	result.ArchiveSize = size
+
+	if err != nil {
+		slog.Error("Failed to stream archive", "jobName", jobName, "error", err)
+		result.Fail(err)
+		return
+	}
+	slog.Info("Archive streamed for job", "job_name", jobName, "archive_name", archiveName, "size", size)
+	remotestorage.UploadManifest(ctx, cfg, job, destinations, m)
+}
+
+// streamSources writes every source of the job into the archive, recording
+// every file in m, and reports the outcome of each one.
+func streamSources(ctx context.Context, cfg *models.Config, job models.JobConfig, tarWriter *tar.Writer, m *manifest.Manifest) []models.SourceResult {
+	var results []models.SourceResult
+
+	for _, dirName := range job.Directories {
+		start := time.Now()
+		var err error
+		var absPath string
+		if dirConfig, ok := cfg.Sources.Directories[dirName]; ok {
+			absPath, _ = filepath.Abs(dirConfig.Path)
+			err = streamDirectory(tarWriter, dirName, dirConfig, m)
+		} else {
+			err = fmt.Errorf("directory %q not found in sources", dirName)
+		}
+		result := sourceResult(dirName, "directory", start, err)
+		result.Path = absPath
+		result.ArchivePath = path.Join(util.FilesDir, dirName)
+		results = append(results, result)
+	}
+
+	for _, dbName := range job.Databases {
+		start := time.Now()
+		var err error
+		if dbConfig, ok := cfg.Sources.Databases[dbName]; ok {
+			err = streamDatabase(ctx, job, dbName, dbConfig, tarWriter, m)
+		} else {
+			err = fmt.Errorf("database %q not found in sources", dbName)
+		}
+		result := sourceResult(dbName, "database", start, err)
+		result.ArchivePath = path.Join(util.DatabasesDir, dbName)
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// streamDirectory adds the entries of a directory source its filter keeps to
+// the archive, below files/<source name>.
+func streamDirectory(tarWriter *tar.Writer, name string, dir models.DirectoryConfig, m *manifest.Manifest) error {
+	slog.Info("Streaming directory", "dir", dir.Path)
+	f, err := filter.New(name, dir)
+	if err != nil {
+		return fmt.Errorf("directory %q: %w", dir.Path, err)
+	}
+	if err := util.WriteTree(tarWriter, dir.Path, path.Join(util.FilesDir, name), m, f, nil); err != nil {
+		return err
+	}
+	f.LogSummary()
+	return nil
+}
+
+// streamDatabase spools a database dump to the output directory and adds it to
+// the archive, below databases/<source name>.
+func streamDatabase(ctx context.Context, job models.JobConfig, name string, db models.DatabaseConfig, tarWriter *tar.Writer, m *manifest.Manifest) error {
+	spoolDir, err := os.MkdirTemp(job.Output.Dir, job.Output.Name+"-spool-")
+	if err != nil {
+		return fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(spoolDir); err != nil {
+			slog.Error("Failed to cleanup spool directory", "spoolDir", spoolDir, "error", err)
+		}
+	}()
+
+	if err := database.BackupDatabase(ctx, db, spoolDir, !compression.Enabled(job.Output.Compression)); err != nil {
+		return err
+	}
+	return util.WriteTree(tarWriter, spoolDir, path.Join(util.DatabasesDir, name), m, nil, nil)
+}
+
+// sourceResult builds the outcome of backing up a single source.
+func sourceResult(name, kind string, start time.Time, err error) models.SourceResult {
+	result := models.SourceResult{Name: name, Kind: kind, Status: models.StatusSuccess, Duration: time.Since(start)}
+	if err != nil {
+		slog.Error("Failed to stream source", "source", name, "kind", kind, "error", err)
+		result.Status = models.StatusFailed
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -2,17 +2,41 @@ package util
 
 import (
 	"archive/tar"
-	"compress/gzip"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/tderick/backup-companion-go/internal/backup/compression"
+	"github.com/tderick/backup-companion-go/internal/backup/encryption"
+	"github.com/tderick/backup-companion-go/internal/backup/filter"
+	"github.com/tderick/backup-companion-go/internal/backup/fsmeta"
+	"github.com/tderick/backup-companion-go/internal/backup/manifest"
 	"github.com/tderick/backup-companion-go/internal/models"
 )
 
+// TimestampLayout is the layout of the timestamp CreateBackupDir appends to
+// the output name, and therefore of every archive and object name.
+const TimestampLayout = "2006-01-02-15-04-05"
+
+// FilesDir and DatabasesDir are the directories of an archive that hold
+// directory sources and database dumps, each below a directory named after
+// its source.
+const (
+	FilesDir     = "files"
+	DatabasesDir = "databases"
+)
+
+// ArchiveExtension returns the extension of the archives CreateArchive
+// creates with cfg, e.g. ".tar.gz" or ".tar" when they are not compressed.
+func ArchiveExtension(cfg models.CompressionConfig) string {
+	return ".tar" + compression.Extension(compression.Algorithm(cfg))
+}
+
 func CreateBackupDir(output models.OutputConfig) (string, error) {
 	if _, err := os.Stat(output.Dir); os.IsNotExist(err) {
 		if err := os.MkdirAll(output.Dir, 0755); err != nil {
@@ -20,8 +44,7 @@ func CreateBackupDir(output models.OutputConfig) (string, error) {
 		}
 	}
 
-	timestamp := time.Now().Format("2006-01-02-15-04-05")
-	backupDir := filepath.Join(output.Dir, output.Name+"-"+timestamp)
+	backupDir := filepath.Join(output.Dir, BackupName(output, time.Now()))
 
 	if err := os.MkdirAll(backupDir, 0755); err != nil {
 		return "", fmt.Errorf("error creating backup directory: %v", err)
@@ -29,7 +52,48 @@ func CreateBackupDir(output models.OutputConfig) (string, error) {
 	return backupDir, nil
 }
 
-func CreateTarGz(sourceDir, targetFile string) error {
+// BackupName returns the name of a backup taken at t: the output name
+// followed by the timestamp. Archives add ArchiveExtension to it.
+func BackupName(output models.OutputConfig, t time.Time) string {
+	return output.Name + "-" + t.Format(TimestampLayout)
+}
+
+// ParseArchiveName extracts the backup time from an archive or object name of
+// the form <name>-<timestamp>.tar, followed by the extension of any
+// compression algorithm and optionally by the encryption extension. It
+// reports false for anything else, including archives of other jobs whose
+// name merely starts with name.
+func ParseArchiveName(name, objectName string) (time.Time, bool) {
+	base := strings.TrimSuffix(filepath.Base(objectName), encryption.Extension)
+	prefix := name + "-"
+	if !strings.HasPrefix(base, prefix) {
+		return time.Time{}, false
+	}
+
+	timestamp, found := "", false
+	for _, extension := range compression.Extensions() {
+		if timestamp, found = strings.CutSuffix(strings.TrimPrefix(base, prefix), ".tar"+extension); found {
+			break
+		}
+	}
+	if !found {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(TimestampLayout, timestamp, time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// CreateArchive archives the contents of sourceDir into targetFile,
+// compressed as cfg selects. When m is not nil, every file is recorded in it
+// and the manifest is added as the last entry of the archive; its sources
+// must already be set with m.Finish. Entries listed in originals are recorded
+// with the metadata given there. When sum is not nil, everything written
+// to targetFile is also written to it, so that the archive can be checksummed
+// without reading it back.
+func CreateArchive(sourceDir, targetFile string, cfg models.CompressionConfig, m *manifest.Manifest, originals Originals, sum io.Writer) error {
 	slog.Info("Creating archive", "sourceDir", sourceDir, "targetFile", targetFile)
 	file, err := os.Create(targetFile)
 	if err != nil {
@@ -37,43 +101,428 @@ func CreateTarGz(sourceDir, targetFile string) error {
 	}
 	defer file.Close()
 
-	gzWriter := gzip.NewWriter(file)
-	defer gzWriter.Close()
+	var w io.Writer = file
+	if sum != nil {
+		w = io.MultiWriter(file, sum)
+	}
+	compressor, err := compression.NewWriter(w, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to compress archive %q: %v", targetFile, err)
+	}
+	defer compressor.Close()
+	tarWriter := tar.NewWriter(compressor)
+
+	if err := WriteTree(tarWriter, sourceDir, "", m, nil, originals); err != nil {
+		return err
+	}
+	if m != nil {
+		if err := m.WriteTo(tarWriter); err != nil {
+			return err
+		}
+	}
+
+	// Close explicitly so that a failure to flush the archive is not lost.
+	if err := tarWriter.Close(); err != nil {
+		return fmt.Errorf("failed to finish tar stream of %q: %v", targetFile, err)
+	}
+	if err := compressor.Close(); err != nil {
+		return fmt.Errorf("failed to finish compressed stream of %q: %v", targetFile, err)
+	}
+	return file.Close()
+}
+
+// Owner is the numeric user and group owning a file.
+type Owner struct {
+	UID int
+	GID int
+}
 
-	tarWriter := tar.NewWriter(gzWriter)
-	defer tarWriter.Close()
+// Original is the metadata of the original of a copy that the copy could not
+// be given, and that is recorded in the archive in place of the copy's.
+type Original struct {
+	// Owner is nil when the copy has the owner of its original.
+	Owner *Owner
+	// Xattrs are nil when the copy has the extended attributes of its
+	// original.
+	Xattrs map[string]string
+}
+
+// Originals maps archive entry names to the metadata of their originals.
+type Originals map[string]Original
 
+// WriteTree adds the contents of sourceDir to the archive, with entry names
+// relative to sourceDir and placed under prefix. Regular files are recorded
+// in m, with their checksum, unless m is nil. Entries f excludes are left
+// out; a nil f keeps everything. Entries listed in originals are recorded
+// with the metadata given there.
+//
+// Symlinks are stored as symlinks, extended attributes (including POSIX
+// ACLs) as PAX records, and every further hardlink to a file as a link to
+// its first path. Special files, such as sockets and devices, are skipped,
+// as when directories are copied before being archived.
+func WriteTree(tarWriter *tar.Writer, sourceDir, prefix string, m *manifest.Manifest, f *filter.Filter, originals Originals) error {
+	links := make(map[fsmeta.FileID]string)
 	return filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
+		if skip, err := f.Skip(path, info); err != nil || skip {
+			if skip && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return err
+		}
+
+		relPath, err := filepath.Rel(sourceDir, path)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path for %q from %q: %v", path, sourceDir, err)
+		}
+		if relPath == "." && prefix == "" {
+			return nil // The archive root needs no entry of its own
+		}
+
+		if mode := info.Mode(); !mode.IsDir() && !mode.IsRegular() && mode&os.ModeSymlink == 0 {
+			slog.Warn("Skipping special file", "path", path, "mode", mode.String())
+			return nil
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return fmt.Errorf("failed to read symlink %q: %v", path, err)
+			}
+		}
 
-		header, err := tar.FileInfoHeader(info, info.Name())
+		header, err := tar.FileInfoHeader(info, link)
 		if err != nil {
 			return fmt.Errorf("failed to create tar header for %q: %v", path, err)
 		}
+		header.Name = filepath.ToSlash(filepath.Join(prefix, relPath)) // Store relative path in archive
+		original := originals[header.Name]
+		if owner := original.Owner; owner != nil {
+			// The names looked up are those of the copy's owner; restoring
+			// only uses the numeric IDs.
+			header.Uid, header.Gid = owner.UID, owner.GID
+			header.Uname, header.Gname = "", ""
+		}
 
-		relPath, err := filepath.Rel(sourceDir, path)
+		xattrs := original.Xattrs
+		if xattrs == nil {
+			if xattrs, err = fsmeta.Xattrs(path); err != nil {
+				return err
+			}
+		}
+		for name, value := range xattrs {
+			if header.PAXRecords == nil {
+				header.PAXRecords = make(map[string]string)
+			}
+			header.PAXRecords[fsmeta.PAXPrefix+name] = value
+		}
+
+		if id, nlink, ok := fsmeta.Identity(info); ok && nlink > 1 && info.Mode().IsRegular() {
+			if first, seen := links[id]; seen {
+				header.Typeflag = tar.TypeLink
+				header.Linkname = first
+				header.Size = 0
+			} else {
+				links[id] = header.Name
+			}
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			if err := tarWriter.WriteHeader(header); err != nil {
+				return fmt.Errorf("failed to write tar header for %q: %v", path, err)
+			}
+			return nil
+		}
+
+		// Open the file before writing its header so that an unreadable file
+		// is reported without leaving a truncated entry in the archive.
+		file, err := os.Open(path)
 		if err != nil {
-			return fmt.Errorf("failed to get relative path for %q from %q: %v", path, sourceDir, err)
+			return fmt.Errorf("failed to open file %q: %v", path, err)
 		}
-		header.Name = relPath // Store relative path in archive
+		defer file.Close()
 
 		if err := tarWriter.WriteHeader(header); err != nil {
 			return fmt.Errorf("failed to write tar header for %q: %v", path, err)
 		}
+		if m == nil {
+			return copyEntry(tarWriter, file, header.Size, path)
+		}
+
+		hash := sha256.New()
+		if err := copyEntry(io.MultiWriter(tarWriter, hash), file, header.Size, path); err != nil {
+			return err
+		}
+		m.AddFile(header, hash.Sum(nil))
+		return nil
+	})
+}
+
+// copyEntry copies exactly size bytes of file, the size recorded in its tar
+// header, to w. Sources are read while other processes may write them: a
+// file that shrank since its header was written is padded with zeros and
+// one that grew is cut at size, so that a single changing file does not
+// abort the whole archive. Both are logged.
+func copyEntry(w io.Writer, file io.Reader, size int64, path string) error {
+	n, err := io.CopyN(w, file, size)
+	if err == io.EOF {
+		slog.Warn("File shrank while being archived, padding it with zeros", "path", path, "size", size, "read", n)
+		_, err = io.CopyN(w, zeroReader{}, size-n)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy file contents from %q to archive: %v", path, err)
+	}
+
+	if extra, _ := file.Read(make([]byte, 1)); extra > 0 {
+		slog.Warn("File grew while being archived, keeping only its original size", "path", path, "size", size)
+	}
+	return nil
+}
+
+// zeroReader reads an endless stream of zeros.
+type zeroReader struct{}
 
-		if !info.IsDir() {
-			file, err := os.Open(path)
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// ExtractArchive unpacks an archive created by CreateArchive into targetDir,
+// whichever algorithm it was compressed with. Entries that would escape
+// targetDir are rejected.
+func ExtractArchive(archiveFile, targetDir string) error {
+	file, err := os.Open(archiveFile)
+	if err != nil {
+		return fmt.Errorf("failed to open archive file %q: %v", archiveFile, err)
+	}
+	defer file.Close()
+
+	return ExtractArchiveReader(file, archiveFile, targetDir)
+}
+
+// ExtractArchiveReader is like ExtractArchive but reads the archive from r.
+// name identifies the archive in logs and errors.
+//
+// Entries get back their permissions, modification times and extended
+// attributes, and their owners when running as root. Symlinks and hardlinks
+// are recreated as such.
+func ExtractArchiveReader(r io.Reader, name, targetDir string) error {
+	slog.Info("Extracting archive", "archiveFile", name, "targetDir", targetDir)
+
+	decompressor, err := compression.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to read compressed stream of %q: %v", name, err)
+	}
+	defer decompressor.Close()
+
+	targetDir = filepath.Clean(targetDir)
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %q: %v", targetDir, err)
+	}
+	resolvedDir, err := filepath.EvalSymlinks(targetDir)
+	if err != nil {
+		return fmt.Errorf("failed to resolve directory %q: %v", targetDir, err)
+	}
+
+	// Directories get their metadata once their contents are extracted, so
+	// that extracting does not change their times and read-only directories
+	// can still be filled.
+	type extractedDir struct {
+		path   string
+		header *tar.Header
+	}
+	var dirs []extractedDir
+
+	tarReader := tar.NewReader(decompressor)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar entry from %q: %v", name, err)
+		}
+
+		targetPath, err := entryPath(targetDir, resolvedDir, header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if targetPath == targetDir {
+				targetPath = resolvedDir
+			} else if err := extractDir(targetPath); err != nil {
+				return err
+			}
+			dirs = append(dirs, extractedDir{path: targetPath, header: header})
+			continue
+		case tar.TypeReg:
+			if err := extractFile(tarReader, targetPath, 0600); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := extractLink(os.Symlink, header.Linkname, targetPath); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			linkTarget, err := entryPath(targetDir, resolvedDir, header.Linkname)
 			if err != nil {
-				return fmt.Errorf("failed to open file %q: %v", path, err)
+				return err
 			}
-			defer file.Close()
-
-			if _, err := io.Copy(tarWriter, file); err != nil {
-				return fmt.Errorf("failed to copy file contents from %q to archive: %v", path, err)
+			// A hardlink shares the metadata of the file it links to
+			if err := extractLink(os.Link, linkTarget, targetPath); err != nil {
+				return err
 			}
+			continue
+		default:
+			slog.Warn("Skipping unsupported archive entry", "name", header.Name, "type", string(header.Typeflag))
+			continue
+		}
+
+		if err := restoreMetadata(targetPath, header); err != nil {
+			return err
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := restoreMetadata(dirs[i].path, dirs[i].header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entryPath returns the path an archive entry is extracted to, rejecting
+// entries that would escape targetDir, whether through their name or
+// through a symlink extracted earlier. resolvedDir is targetDir with its own
+// symlinks resolved.
+func entryPath(targetDir, resolvedDir, name string) (string, error) {
+	targetPath := filepath.Join(targetDir, name)
+	if !isWithin(targetDir, targetPath) {
+		return "", fmt.Errorf("archive entry %q escapes the target directory", name)
+	}
+	if targetPath == targetDir {
+		return targetPath, nil
+	}
+
+	// Resolve the deepest parent that exists so far; the rest is created
+	// as plain directories.
+	parent := filepath.Dir(targetPath)
+	for parent != targetDir {
+		if _, err := os.Lstat(parent); err == nil {
+			break
 		}
+		parent = filepath.Dir(parent)
+	}
+	resolved, err := filepath.EvalSymlinks(parent)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve directory %q: %v", parent, err)
+	}
+	if !isWithin(resolvedDir, resolved) {
+		return "", fmt.Errorf("archive entry %q escapes the target directory through a symlink", name)
+	}
+	return targetPath, nil
+}
+
+// isWithin reports whether path is dir or below it.
+func isWithin(dir, path string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
+
+// extractDir creates targetPath as a directory. Anything else already there
+// is replaced, so that a symlink extracted earlier is not followed.
+func extractDir(targetPath string) error {
+	info, err := os.Lstat(targetPath)
+	if err == nil && info.IsDir() {
 		return nil
-	})
+	}
+	if err == nil {
+		if err := os.Remove(targetPath); err != nil {
+			return fmt.Errorf("failed to replace %q: %v", targetPath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to stat %q: %v", targetPath, err)
+	}
+	if err := os.MkdirAll(targetPath, 0700); err != nil {
+		return fmt.Errorf("failed to create directory %q: %v", targetPath, err)
+	}
+	return nil
+}
+
+// extractFile writes the current tar entry to targetPath, replacing whatever
+// is there.
+func extractFile(r io.Reader, targetPath string, mode os.FileMode) error {
+	if err := prepareTarget(targetPath); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, mode)
+	if err != nil {
+		return fmt.Errorf("failed to create file %q: %v", targetPath, err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, r); err != nil {
+		return fmt.Errorf("failed to extract file %q: %v", targetPath, err)
+	}
+	return file.Close()
+}
+
+// extractLink creates targetPath as a link to linkTarget with link, which is
+// os.Symlink or os.Link, replacing whatever is there.
+func extractLink(link func(oldname, newname string) error, linkTarget, targetPath string) error {
+	if err := prepareTarget(targetPath); err != nil {
+		return err
+	}
+	if err := link(linkTarget, targetPath); err != nil {
+		return fmt.Errorf("failed to create link %q: %v", targetPath, err)
+	}
+	return nil
+}
+
+// prepareTarget creates the parent directories of targetPath and removes
+// what is already there, so that an existing symlink is replaced instead of
+// followed.
+func prepareTarget(targetPath string) error {
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %q: %v", targetPath, err)
+	}
+	if err := os.Remove(targetPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to replace %q: %v", targetPath, err)
+	}
+	return nil
+}
+
+// restoreMetadata gives an extracted entry the owner, permissions, extended
+// attributes and modification time recorded in its header. Owners are only
+// restored when running as root, by numeric ID. Extended attributes that
+// cannot be set, e.g. because the filesystem lacks support for them, are
+// logged and skipped.
+func restoreMetadata(path string, header *tar.Header) error {
+	if fsmeta.CanChown() {
+		if err := os.Lchown(path, header.Uid, header.Gid); err != nil {
+			return fmt.Errorf("failed to set owner of %q: %v", path, err)
+		}
+	}
+	// Directories get their metadata after the rest of the archive is
+	// extracted, by which time a later entry may have replaced one with a
+	// symlink; nothing here follows symlinks.
+	if err := fsmeta.Chmod(path, fsmeta.Mode(header.FileInfo())); err != nil {
+		return err
+	}
+
+	xattrs := make(map[string]string)
+	for key, value := range header.PAXRecords {
+		if name, ok := strings.CutPrefix(key, fsmeta.PAXPrefix); ok {
+			xattrs[name] = value
+		}
+	}
+	if err := fsmeta.SetXattrs(path, xattrs); err != nil {
+		slog.Warn("Failed to restore extended attributes", "path", path, "error", err)
+	}
+
+	return fsmeta.Chtimes(path, header.ModTime)
 }
@@ -0,0 +1,234 @@
+package util
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tderick/backup-companion-go/internal/backup/manifest"
+)
+
+// hookWriter calls hook before its first write, which for a tar writer is the
+// header of the first entry.
+type hookWriter struct {
+	w    io.Writer
+	hook func()
+}
+
+func (h *hookWriter) Write(p []byte) (int, error) {
+	if h.hook != nil {
+		h.hook()
+		h.hook = nil
+	}
+	return h.w.Write(p)
+}
+
+func TestWriteTreeFileChangesWhileArchived(t *testing.T) {
+	original := []byte("0123456789")
+	tests := []struct {
+		name   string
+		change []byte
+		want   []byte
+	}{
+		{"shrinks", []byte("0123"), []byte("0123\x00\x00\x00\x00\x00\x00")},
+		{"grows", []byte("0123456789abcdef"), []byte("0123456789")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			changing := filepath.Join(dir, "a.log")
+			if err := os.WriteFile(changing, original, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "b.txt"), []byte("intact"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			// Change a.log once its size is in the header but before its
+			// contents are read.
+			var archive bytes.Buffer
+			w := &hookWriter{w: &archive, hook: func() {
+				if err := os.WriteFile(changing, tt.change, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}}
+			tarWriter := tar.NewWriter(w)
+			m := manifest.New("job", time.Now())
+			if err := WriteTree(tarWriter, dir, "", m, nil, nil); err != nil {
+				t.Fatalf("WriteTree() error = %v", err)
+			}
+			if err := tarWriter.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			contents := readArchive(t, &archive)
+			if got := contents["a.log"]; !bytes.Equal(got, tt.want) {
+				t.Errorf("a.log = %q, want %q", got, tt.want)
+			}
+			if got := contents["b.txt"]; string(got) != "intact" {
+				t.Errorf("b.txt = %q, want %q", got, "intact")
+			}
+			if len(m.Files) != 2 || m.Files[0].Size != int64(len(original)) {
+				t.Errorf("manifest files = %+v, want a.log with size %d and b.txt", m.Files, len(original))
+			}
+		})
+	}
+}
+
+func TestWriteTreeSkipsSpecialFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("kept"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("unix", filepath.Join(dir, "app.sock"))
+	if err != nil {
+		t.Skipf("unix sockets are not supported here: %v", err)
+	}
+	defer listener.Close()
+
+	var archive bytes.Buffer
+	tarWriter := tar.NewWriter(&archive)
+	m := manifest.New("job", time.Now())
+	if err := WriteTree(tarWriter, dir, "files/app", m, nil, nil); err != nil {
+		t.Fatalf("WriteTree() error = %v", err)
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	tarReader := tar.NewReader(&archive)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read archive: %v", err)
+		}
+		names = append(names, header.Name)
+	}
+	if want := []string{"files/app", "files/app/a.txt"}; !slices.Equal(names, want) {
+		t.Errorf("archive entries = %q, want %q", names, want)
+	}
+}
+
+// readArchive returns the contents of every regular file in a tar stream.
+func readArchive(t *testing.T, r io.Reader) map[string][]byte {
+	t.Helper()
+	contents := make(map[string][]byte)
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return contents
+		}
+		if err != nil {
+			t.Fatalf("failed to read archive: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tarReader)
+		if err != nil {
+			t.Fatalf("failed to read %q: %v", header.Name, err)
+		}
+		contents[header.Name] = data
+	}
+}
+
+func TestExtractArchiveReaderDoesNotFollowSymlinks(t *testing.T) {
+	dir := func(name string) *tar.Header {
+		return &tar.Header{Typeflag: tar.TypeDir, Name: name, Mode: 0777, ModTime: time.Unix(0, 0)}
+	}
+	symlink := func(name, target string) *tar.Header {
+		return &tar.Header{Typeflag: tar.TypeSymlink, Name: name, Linkname: target}
+	}
+	file := func(name string) *tar.Header {
+		return &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0666}
+	}
+
+	tests := []struct {
+		name    string
+		entries func(outside string) []*tar.Header
+		wantErr string
+	}{
+		{
+			name: "directory entry over a symlink",
+			entries: func(outside string) []*tar.Header {
+				return []*tar.Header{symlink("x", outside), dir("x")}
+			},
+		},
+		{
+			name: "directory replaced by a symlink",
+			entries: func(outside string) []*tar.Header {
+				return []*tar.Header{dir("x"), symlink("x", outside)}
+			},
+		},
+		{
+			name: "file through a symlink",
+			entries: func(outside string) []*tar.Header {
+				return []*tar.Header{symlink("x", outside), file("x/f")}
+			},
+			wantErr: "escapes the target directory through a symlink",
+		},
+		{
+			name: "directory through a symlink",
+			entries: func(outside string) []*tar.Header {
+				return []*tar.Header{symlink("x", outside), dir("x/sub")}
+			},
+			wantErr: "escapes the target directory through a symlink",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outside := t.TempDir()
+			if err := os.Chmod(outside, 0700); err != nil {
+				t.Fatal(err)
+			}
+			modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+			if err := os.Chtimes(outside, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+
+			var archive bytes.Buffer
+			tarWriter := tar.NewWriter(&archive)
+			for _, header := range tt.entries(outside) {
+				if err := tarWriter.WriteHeader(header); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := tarWriter.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			target := t.TempDir()
+			err := ExtractArchiveReader(&archive, "crafted.tar", target)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ExtractArchiveReader() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("ExtractArchiveReader() error = %v", err)
+			}
+
+			info, err := os.Stat(outside)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0700 || !info.ModTime().Equal(modTime) {
+				t.Errorf("directory outside the target changed: mode %v, modified %v", info.Mode(), info.ModTime())
+			}
+			if entries, _ := os.ReadDir(outside); len(entries) > 0 {
+				t.Errorf("%d entries were extracted outside the target", len(entries))
+			}
+		})
+	}
+}
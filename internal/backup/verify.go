@@ -0,0 +1,259 @@
+package backup
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tderick/backup-companion-go/internal/backup/compression"
+	"github.com/tderick/backup-companion-go/internal/backup/database"
+	"github.com/tderick/backup-companion-go/internal/backup/encryption"
+	"github.com/tderick/backup-companion-go/internal/backup/manifest"
+	"github.com/tderick/backup-companion-go/internal/backup/objectkey"
+	"github.com/tderick/backup-companion-go/internal/backup/remotestorage"
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+// VerifyOptions selects the backups to verify.
+type VerifyOptions struct {
+	Job string
+	// Destination to verify; defaults to every destination of the job.
+	Destination string
+	// Snapshot is the object key or archive name of the backup to verify.
+	// When empty the latest backup is verified, or every backup when All is set.
+	Snapshot string
+	All      bool
+}
+
+// VerifyResult is the outcome of verifying a single archive on a destination.
+type VerifyResult struct {
+	Job         string        `json:"job"`
+	Destination string        `json:"destination"`
+	Key         string        `json:"key,omitempty"`
+	Status      models.Status `json:"status"`
+	Error       string        `json:"error,omitempty"`
+	// Files is the number of regular files read from the archive.
+	Files int `json:"files"`
+	// Manifest reports whether the archive had a manifest to check the
+	// files against. Without one only the archive framing is checked.
+	Manifest bool          `json:"manifest"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Verify downloads the selected backups of a job from each destination and
+// checks them end-to-end: the archive is decrypted and decompressed, every
+// file is compared against the checksums of the manifest, and PostgreSQL
+// dumps are checked with pg_restore. It returns an error only for invalid
+// options; the outcome for each archive is in the results.
+func Verify(ctx context.Context, cfg *models.Config, opts VerifyOptions) ([]VerifyResult, error) {
+	job, ok := cfg.Jobs[opts.Job]
+	if !ok {
+		return nil, fmt.Errorf("unknown job %q", opts.Job)
+	}
+
+	destNames := job.Destinations
+	if opts.Destination != "" {
+		if _, ok := cfg.Destinations[opts.Destination]; !ok {
+			return nil, fmt.Errorf("unknown destination %q", opts.Destination)
+		}
+		destNames = []string{opts.Destination}
+	}
+
+	var results []VerifyResult
+	for _, destName := range destNames {
+		results = append(results, verifyDestination(ctx, cfg, opts, job, destName)...)
+	}
+	return results, nil
+}
+
+// verifyDestination verifies the selected backups of a job on one destination.
+func verifyDestination(ctx context.Context, cfg *models.Config, opts VerifyOptions, job models.JobConfig, destName string) []VerifyResult {
+	failed := func(err error) []VerifyResult {
+		slog.Error("Failed to verify backups on destination", "job_name", opts.Job, "destination", destName, "error", err)
+		return []VerifyResult{{Job: opts.Job, Destination: destName, Status: models.StatusFailed, Error: err.Error()}}
+	}
+
+	destConfig := cfg.Destinations[destName]
+	dest, err := remotestorage.New(ctx, destConfig)
+	if err != nil {
+		return failed(fmt.Errorf("failed to create destination client: %w", err))
+	}
+
+	layout, err := objectkey.New(opts.Job, job, destConfig)
+	if err != nil {
+		return failed(err)
+	}
+	archives, err := listJobArchives(ctx, dest, layout)
+	if err != nil {
+		return failed(err)
+	}
+	archives, err = selectArchives(archives, opts)
+	if err != nil {
+		return failed(err)
+	}
+
+	enc := encryption.Effective(job, destConfig)
+	results := make([]VerifyResult, 0, len(archives))
+	for _, archive := range archives {
+		result := VerifyResult{Job: opts.Job, Destination: destName, Key: archive.Key}
+		start := time.Now()
+
+		slog.Info("Verifying backup", "job_name", opts.Job, "destination", destName, "key", archive.Key)
+		result.Files, result.Manifest, err = verifyArchive(ctx, dest, archive.Key, enc)
+		result.Duration = time.Since(start)
+		if err != nil {
+			slog.Error("Backup verification failed", "job_name", opts.Job, "destination", destName, "key", archive.Key, "error", err)
+			result.Status = models.StatusFailed
+			result.Error = err.Error()
+		} else {
+			if !result.Manifest {
+				slog.Warn("Backup has no manifest, only its framing was checked", "destination", destName, "key", archive.Key)
+			}
+			slog.Info("Backup verified", "job_name", opts.Job, "destination", destName, "key", archive.Key, "files", result.Files)
+			result.Status = models.StatusSuccess
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// selectArchives picks the archives selected by opts, oldest first.
+func selectArchives(archives []archiveObject, opts VerifyOptions) ([]archiveObject, error) {
+	sort.Slice(archives, func(i, j int) bool { return archives[i].Time.Before(archives[j].Time) })
+
+	switch {
+	case opts.Snapshot != "":
+		if archive, ok := findArchive(archives, opts.Snapshot); ok {
+			return []archiveObject{archive}, nil
+		}
+		// Not one of the job's archives, e.g. stored under an earlier prefix.
+		return []archiveObject{{ObjectInfo: remotestorage.ObjectInfo{Key: opts.Snapshot}}}, nil
+	case len(archives) == 0:
+		return nil, errors.New("no backup found")
+	case opts.All:
+		return archives, nil
+	default:
+		return archives[len(archives)-1:], nil
+	}
+}
+
+// archiveEntry is a regular file read from an archive being verified.
+type archiveEntry struct {
+	size   int64
+	sha256 string
+}
+
+// verifyArchive streams an archive from the destination and checks it. It
+// returns the number of regular files in the archive and whether it had a
+// manifest.
+func verifyArchive(ctx context.Context, dest remotestorage.Destination, objectKey string, enc *models.EncryptionConfig) (int, bool, error) {
+	body, err := dest.Download(ctx, objectKey)
+	if err != nil {
+		return 0, false, err
+	}
+	defer body.Close()
+
+	var r io.Reader = body
+	if encryption.IsEncrypted(objectKey) {
+		if enc == nil {
+			return 0, false, fmt.Errorf("archive %q is encrypted but the job and destination have no encryption settings", objectKey)
+		}
+		if r, err = encryption.Decrypt(body, *enc); err != nil {
+			return 0, false, err
+		}
+	}
+
+	decompressor, err := compression.NewReader(r)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read compressed stream: %w", err)
+	}
+	defer decompressor.Close()
+
+	var m *manifest.Manifest
+	entries := make(map[string]archiveEntry)
+	tarReader := tar.NewReader(decompressor)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return len(entries), m != nil, fmt.Errorf("failed to read tar entry: %w", err)
+		}
+
+		if header.Name == manifest.FileName {
+			if m, err = manifest.Read(tarReader); err != nil {
+				return len(entries), false, err
+			}
+			continue
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		entry, err := verifyEntry(ctx, tarReader, header)
+		if err != nil {
+			return len(entries), m != nil, err
+		}
+		entries[header.Name] = entry
+	}
+
+	// The tar reader stops at the end-of-archive marker; read the rest so
+	// that the checksum of the compressed stream, and any encryption, is
+	// checked too.
+	if _, err := io.Copy(io.Discard, decompressor); err != nil {
+		return len(entries), m != nil, fmt.Errorf("failed to read end of archive: %w", err)
+	}
+
+	if m == nil {
+		return len(entries), false, nil
+	}
+	return len(entries), true, compareManifest(m, entries)
+}
+
+// verifyEntry reads the current file of the archive, computing its checksum
+// and checking PostgreSQL dumps with pg_restore.
+func verifyEntry(ctx context.Context, r io.Reader, header *tar.Header) (archiveEntry, error) {
+	hash := sha256.New()
+	if strings.HasSuffix(header.Name, ".pgdump") {
+		if err := database.CheckPostgresDump(ctx, io.TeeReader(r, hash)); err != nil {
+			return archiveEntry{}, fmt.Errorf("dump %q: %w", header.Name, err)
+		}
+	}
+	if _, err := io.Copy(hash, r); err != nil {
+		return archiveEntry{}, fmt.Errorf("failed to read %q from archive: %w", header.Name, err)
+	}
+	return archiveEntry{size: header.Size, sha256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// compareManifest checks the files read from an archive against its manifest.
+func compareManifest(m *manifest.Manifest, entries map[string]archiveEntry) error {
+	var errs []error
+	listed := make(map[string]bool, len(m.Files))
+	for _, file := range m.Files {
+		listed[file.Path] = true
+		entry, ok := entries[file.Path]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("file %q listed in the manifest is missing from the archive", file.Path))
+		case entry.size != file.Size:
+			errs = append(errs, fmt.Errorf("file %q has size %d, the manifest says %d", file.Path, entry.size, file.Size))
+		case entry.sha256 != file.SHA256:
+			errs = append(errs, fmt.Errorf("file %q does not match its checksum in the manifest", file.Path))
+		}
+	}
+	for path := range entries {
+		if !listed[path] {
+			errs = append(errs, fmt.Errorf("file %q is not listed in the manifest", path))
+		}
+	}
+	return errors.Join(errs...)
+}
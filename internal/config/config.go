@@ -7,6 +7,11 @@ import (
 
 	"github.com/go-playground/validator/v10"
 	"github.com/spf13/viper"
+	"github.com/tderick/backup-companion-go/internal/backup/compression"
+	"github.com/tderick/backup-companion-go/internal/backup/encryption"
+	"github.com/tderick/backup-companion-go/internal/backup/filter"
+	"github.com/tderick/backup-companion-go/internal/backup/objectkey"
+	"github.com/tderick/backup-companion-go/internal/backup/remotestorage"
 	"github.com/tderick/backup-companion-go/internal/models"
 )
 
@@ -28,6 +33,16 @@ func LoadConfig(configFile string) (*models.Config, error) {
 		return nil, err
 	}
 
+	// Secrets must be in place before validation. Secret files are read
+	// first so that environment variables take precedence over them.
+	if err := resolveSecretFiles(&cfg); err != nil {
+		return nil, err
+	}
+	applyEnvOverrides(&cfg)
+	if err := validateSecrets(&cfg); err != nil {
+		return nil, err
+	}
+
 	// Validate cross-references in jobs
 	if err := validateReferences(&cfg); err != nil {
 		return nil, err
@@ -94,6 +109,58 @@ func validateReferences(cfg *models.Config) error {
 				fmt.Fprintf(&b, "job %q references unknown destination %q\n", jobName, dst)
 			}
 		}
+
+		// Compression
+		if err := compression.Validate(job.Output.Compression); err != nil {
+			fmt.Fprintf(&b, "job %q has invalid compression: %v\n", jobName, err)
+		}
+
+		// Encryption
+		if job.Encryption != nil {
+			if err := encryption.Validate(*job.Encryption); err != nil {
+				fmt.Fprintf(&b, "job %q has invalid encryption: %v\n", jobName, err)
+			}
+		}
+
+		// Object key prefix
+		if err := objectkey.Validate(job.Prefix); err != nil {
+			fmt.Fprintf(&b, "job %q has an invalid prefix: %v\n", jobName, err)
+		}
+	}
+
+	// Source names name the directories sources are stored in within archives
+	for dbName := range cfg.Sources.Databases {
+		if !validSourceName(dbName) {
+			fmt.Fprintf(&b, "database %q has an invalid name, it must not contain slashes\n", dbName)
+		}
+	}
+	for dirName, dir := range cfg.Sources.Directories {
+		if !validSourceName(dirName) {
+			fmt.Fprintf(&b, "directory %q has an invalid name, it must not contain slashes\n", dirName)
+		}
+		if err := filter.Validate(dir); err != nil {
+			fmt.Fprintf(&b, "directory %q: %v\n", dirName, err)
+		}
+	}
+
+	for destName, dest := range cfg.Destinations {
+		if err := remotestorage.CheckConfig(dest); err != nil {
+			fmt.Fprintf(&b, "destination %q: %v\n", destName, err)
+		}
+		if dest.Upload.PartSizeMiB != 0 && dest.Upload.PartSizeMiB < 5 {
+			fmt.Fprintf(&b, "destination %q upload partSizeMiB must be at least 5\n", destName)
+		}
+		if dest.Upload.Concurrency < 0 || dest.Upload.MaxRetries < 0 || dest.Upload.AbortIncompleteAfter < 0 {
+			fmt.Fprintf(&b, "destination %q upload settings must not be negative\n", destName)
+		}
+		if dest.Encryption != nil {
+			if err := encryption.Validate(*dest.Encryption); err != nil {
+				fmt.Fprintf(&b, "destination %q has invalid encryption: %v\n", destName, err)
+			}
+		}
+		if err := objectkey.Validate(dest.Prefix); err != nil {
+			fmt.Fprintf(&b, "destination %q has an invalid prefix: %v\n", destName, err)
+		}
 	}
 
 	if b.Len() > 0 {
@@ -101,3 +168,9 @@ func validateReferences(cfg *models.Config) error {
 	}
 	return nil
 }
+
+// validSourceName reports whether a source name can be used as the name of a
+// directory within archives.
+func validSourceName(name string) bool {
+	return name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
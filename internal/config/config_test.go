@@ -0,0 +1,97 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigValidatesMapValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		extra   string // Appended to the files_only job
+		dest    string // Appended to the nas destination
+		wantErr bool
+	}{
+		{name: "valid"},
+		{name: "schedule", extra: "    schedule:\n      cron: \"@daily\"\n      jitter: 10m\n"},
+		{name: "schedule without cron", extra: "    schedule:\n      timezone: UTC\n", wantErr: true},
+		{name: "negative jitter", extra: "    schedule:\n      cron: \"@daily\"\n      jitter: -1m\n", wantErr: true},
+		{name: "negative job retention", extra: "    retention:\n      keepDaily: -1\n", wantErr: true},
+		{name: "negative destination retention", dest: "    retention:\n      keepLast: -3\n", wantErr: true},
+		{name: "negative max age", dest: "    retention:\n      maxAge: -1h\n", wantErr: true},
+		{name: "destination without provider", dest: "    provider: \"\"\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			content := `sources:
+  directories:
+    files:
+      path: "` + dir + `"
+destinations:
+  nas:
+    provider: local
+    path: "` + dir + `"
+` + tt.dest + `jobs:
+  files_only:
+    output:
+      dir: "` + filepath.Join(dir, "not-created-yet") + `"
+      name: files
+    directories: [files]
+    destinations: [nas]
+` + tt.extra
+
+			path := filepath.Join(dir, "config.yaml")
+			if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := LoadConfig(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigRequiresDatabaseFields(t *testing.T) {
+	dir := t.TempDir()
+	content := `sources:
+  databases:
+    production_db:
+      driver: postgres
+      host: db.internal
+      user: backup
+      password: secret
+      name: app
+destinations:
+  nas:
+    provider: local
+    path: "` + dir + `"
+jobs:
+  database_only:
+    output:
+      dir: "` + dir + `"
+      name: db
+    databases: [production_db]
+    destinations: [nas]
+`
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadConfig(path); err == nil {
+		t.Fatal("LoadConfig() accepted a database without a port")
+	}
+
+	fixed := strings.Replace(content, "      user: backup", "      port: 5432\n      user: backup", 1)
+	if err := os.WriteFile(path, []byte(fixed), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+}
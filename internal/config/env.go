@@ -0,0 +1,97 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+// Prefixes of the environment variables that override secrets in the config
+// file. The normalised source or destination name is appended to them, e.g.
+// BACKUP_COMPANION_DB_PASSWORD_PRODUCTION_DB for the "production_db" source.
+const (
+	envDBPasswordPrefix     = "BACKUP_COMPANION_DB_PASSWORD_"
+	envS3KeyPrefix          = "BACKUP_COMPANION_S3_KEY_"
+	envS3SecretPrefix       = "BACKUP_COMPANION_S3_SECRET_"
+	envSFTPPasswordPrefix   = "BACKUP_COMPANION_SFTP_PASSWORD_"
+	envWebDAVPasswordPrefix = "BACKUP_COMPANION_WEBDAV_PASSWORD_"
+	envAzureKeyPrefix       = "BACKUP_COMPANION_AZURE_KEY_"
+	envAzureSASPrefix       = "BACKUP_COMPANION_AZURE_SAS_"
+	envGCSCredentialsPrefix = "BACKUP_COMPANION_GCS_CREDENTIALS_"
+)
+
+// applyEnvOverrides replaces database passwords and destination credentials
+// with the values of their environment variables, when those are set. It runs
+// after resolveSecretFiles, so a variable also overrides a *File secret.
+func applyEnvOverrides(cfg *models.Config) {
+	for name, db := range cfg.Sources.Databases {
+		if value, ok := lookupEnv(envDBPasswordPrefix, name); ok {
+			db.Password = value
+		}
+		cfg.Sources.Databases[name] = db
+	}
+
+	for name, dest := range cfg.Destinations {
+		// Each variable only applies to the providers it is named after, so
+		// that e.g. a WebDAV password cannot replace an SFTP one.
+		switch dest.Provider {
+		case "s3", "minio":
+			if value, ok := lookupEnv(envS3KeyPrefix, name); ok {
+				dest.AccessKeyID = value
+			}
+			if value, ok := lookupEnv(envS3SecretPrefix, name); ok {
+				dest.SecretAccessKey = value
+			}
+		case "sftp":
+			if value, ok := lookupEnv(envSFTPPasswordPrefix, name); ok {
+				dest.Password = value
+			}
+		case "webdav":
+			if value, ok := lookupEnv(envWebDAVPasswordPrefix, name); ok {
+				dest.Password = value
+			}
+		case "azureblob":
+			if value, ok := lookupEnv(envAzureKeyPrefix, name); ok {
+				dest.AccountKey = value
+			}
+			if value, ok := lookupEnv(envAzureSASPrefix, name); ok {
+				dest.SASToken = value
+			}
+		case "gcs":
+			if value, ok := lookupEnv(envGCSCredentialsPrefix, name); ok {
+				dest.Credentials = value
+			}
+		}
+		cfg.Destinations[name] = dest
+	}
+}
+
+// lookupEnv returns the non-empty value of the override variable for name.
+func lookupEnv(prefix, name string) (string, bool) {
+	key := prefix + envName(name)
+	value := os.Getenv(key)
+	if value == "" {
+		return "", false
+	}
+	slog.Debug("Applying config override from environment", "variable", key)
+	return value, true
+}
+
+// envName normalises a config name for use in an environment variable name:
+// letters are upper-cased, digits are kept, and every other character becomes
+// an underscore. "contabo-primary" and "contabo.primary" both map to
+// "CONTABO_PRIMARY".
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
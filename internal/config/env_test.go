@@ -0,0 +1,158 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+func TestEnvName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"contabo_primary", "CONTABO_PRIMARY"},
+		{"contabo-primary", "CONTABO_PRIMARY"},
+		{"contabo.primary", "CONTABO_PRIMARY"},
+		{"Contabo_Primary", "CONTABO_PRIMARY"},
+		{"db2", "DB2"},
+		{"prod db/eu", "PROD_DB_EU"},
+		{"café", "CAF_"},
+	}
+	for _, tt := range tests {
+		if got := envName(tt.name); got != tt.want {
+			t.Errorf("envName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApplyEnvOverridesNormalisesNames(t *testing.T) {
+	t.Setenv("BACKUP_COMPANION_S3_KEY_CONTABO_PRIMARY", "env-key")
+	t.Setenv("BACKUP_COMPANION_DB_PASSWORD_PRODUCTION_DB", "env-password")
+
+	for _, name := range []string{"contabo-primary", "contabo.primary", "Contabo_Primary"} {
+		cfg := &models.Config{
+			Sources: models.SourcesConfig{Databases: map[string]models.DatabaseConfig{
+				"production-db": {Password: "inline"},
+			}},
+			Destinations: map[string]models.DestinationConfig{
+				name: {Provider: "s3", AccessKeyID: "inline"},
+			},
+		}
+		applyEnvOverrides(cfg)
+
+		if got := cfg.Destinations[name].AccessKeyID; got != "env-key" {
+			t.Errorf("destination %q: AccessKeyID = %q, want %q", name, got, "env-key")
+		}
+		if got := cfg.Sources.Databases["production-db"].Password; got != "env-password" {
+			t.Errorf("Password = %q, want %q", got, "env-password")
+		}
+	}
+}
+
+func TestApplyEnvOverridesMatchesProvider(t *testing.T) {
+	t.Setenv("BACKUP_COMPANION_SFTP_PASSWORD_BOX", "sftp-password")
+	t.Setenv("BACKUP_COMPANION_WEBDAV_PASSWORD_BOX", "webdav-password")
+	t.Setenv("BACKUP_COMPANION_S3_KEY_BOX", "s3-key")
+
+	tests := []struct {
+		provider string
+		want     models.DestinationConfig
+	}{
+		{"sftp", models.DestinationConfig{Provider: "sftp", Password: "sftp-password"}},
+		{"webdav", models.DestinationConfig{Provider: "webdav", Password: "webdav-password"}},
+		{"minio", models.DestinationConfig{Provider: "minio", AccessKeyID: "s3-key"}},
+		{"local", models.DestinationConfig{Provider: "local"}},
+	}
+	for _, tt := range tests {
+		cfg := &models.Config{Destinations: map[string]models.DestinationConfig{
+			"box": {Provider: tt.provider},
+		}}
+		applyEnvOverrides(cfg)
+
+		got := cfg.Destinations["box"]
+		if got.Password != tt.want.Password || got.AccessKeyID != tt.want.AccessKeyID {
+			t.Errorf("provider %q: Password = %q, AccessKeyID = %q, want %q, %q",
+				tt.provider, got.Password, got.AccessKeyID, tt.want.Password, tt.want.AccessKeyID)
+		}
+	}
+}
+
+func TestLoadConfigSecretPrecedence(t *testing.T) {
+	tests := []struct {
+		name    string
+		inline  string
+		file    string
+		env     string
+		want    string
+		wantErr string
+	}{
+		{name: "inline", inline: "inline", want: "inline"},
+		{name: "file", file: "from-file", want: "from-file"},
+		{name: "env over inline", inline: "inline", env: "from-env", want: "from-env"},
+		{name: "env over file", file: "from-file", env: "from-env", want: "from-env"},
+		{name: "inline and file", inline: "inline", file: "from-file", wantErr: "not both"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv("BACKUP_COMPANION_SFTP_PASSWORD_DROP_BOX", tt.env)
+
+			passwordFile := ""
+			if tt.file != "" {
+				passwordFile = filepath.Join(dir, "password")
+				if err := os.WriteFile(passwordFile, []byte(tt.file+"\n"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cfg, err := LoadConfig(writeConfig(t, dir, tt.inline, passwordFile))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadConfig() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+			if got := cfg.Destinations["drop-box"].Password; got != tt.want {
+				t.Errorf("Password = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// writeConfig writes a config with a single SFTP destination, "drop-box",
+// and returns its path.
+func writeConfig(t *testing.T, dir, password, passwordFile string) string {
+	t.Helper()
+	content := `sources:
+  directories:
+    files:
+      path: "` + dir + `"
+destinations:
+  drop-box:
+    provider: sftp
+    host: sftp.example.com
+    user: backup
+    path: /upload
+    password: "` + password + `"
+    passwordFile: "` + passwordFile + `"
+jobs:
+  files_only:
+    output:
+      dir: "` + dir + `"
+      name: files
+    directories: [files]
+    destinations: [drop-box]
+`
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
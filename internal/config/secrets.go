@@ -0,0 +1,132 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+// resolveSecretFiles replaces every *File secret reference (e.g. a Docker or
+// Kubernetes secret mounted under /run/secrets) with the trimmed contents of
+// that file. A secret may be given inline or as a file, but not both.
+func resolveSecretFiles(cfg *models.Config) error {
+	var b strings.Builder
+
+	for name, db := range cfg.Sources.Databases {
+		if err := readSecretFile(&db.Password, db.PasswordFile); err != nil {
+			fmt.Fprintf(&b, "database %q: password: %v\n", name, err)
+		}
+		cfg.Sources.Databases[name] = db
+	}
+
+	for name, dest := range cfg.Destinations {
+		if err := readSecretFile(&dest.AccessKeyID, dest.AccessKeyIDFile); err != nil {
+			fmt.Fprintf(&b, "destination %q: accessKeyId: %v\n", name, err)
+		}
+		if err := readSecretFile(&dest.SecretAccessKey, dest.SecretAccessKeyFile); err != nil {
+			fmt.Fprintf(&b, "destination %q: secretAccessKey: %v\n", name, err)
+		}
+		if err := readSecretFile(&dest.Password, dest.PasswordFile); err != nil {
+			fmt.Fprintf(&b, "destination %q: password: %v\n", name, err)
+		}
+		if err := readSecretFile(&dest.AccountKey, dest.AccountKeyFile); err != nil {
+			fmt.Fprintf(&b, "destination %q: accountKey: %v\n", name, err)
+		}
+		if err := readSecretFile(&dest.SASToken, dest.SASTokenFile); err != nil {
+			fmt.Fprintf(&b, "destination %q: sasToken: %v\n", name, err)
+		}
+		if err := readSecretFile(&dest.Credentials, dest.CredentialsFile); err != nil {
+			fmt.Fprintf(&b, "destination %q: credentials: %v\n", name, err)
+		}
+		if dest.Encryption != nil {
+			if err := readSecretFile(&dest.Encryption.Passphrase, dest.Encryption.PassphraseFile); err != nil {
+				fmt.Fprintf(&b, "destination %q: encryption passphrase: %v\n", name, err)
+			}
+		}
+		cfg.Destinations[name] = dest
+	}
+
+	for name, job := range cfg.Jobs {
+		if job.Encryption != nil {
+			if err := readSecretFile(&job.Encryption.Passphrase, job.Encryption.PassphraseFile); err != nil {
+				fmt.Fprintf(&b, "job %q: encryption passphrase: %v\n", name, err)
+			}
+		}
+	}
+
+	if b.Len() > 0 {
+		return fmt.Errorf("invalid secrets:\n%s", b.String())
+	}
+	return nil
+}
+
+// readSecretFile loads path into value, unless path is empty.
+func readSecretFile(value *string, path string) error {
+	if path == "" {
+		return nil
+	}
+	if *value != "" {
+		return fmt.Errorf("set either the inline value or the file %q, not both", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read secret file: %w", err)
+	}
+
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return fmt.Errorf("secret file %q is empty", path)
+	}
+	*value = secret
+	return nil
+}
+
+// validateSecrets reports database passwords and destination keys that were
+// set neither inline, nor as a file, nor through the environment.
+func validateSecrets(cfg *models.Config) error {
+	var b strings.Builder
+
+	for name, db := range cfg.Sources.Databases {
+		if db.Password == "" {
+			fmt.Fprintf(&b, "database %q requires password, passwordFile or %s%s\n", name, envDBPasswordPrefix, envName(name))
+		}
+	}
+
+	for name, dest := range cfg.Destinations {
+		if dest.Provider == "sftp" {
+			if dest.Password == "" && dest.PrivateKeyFile == "" {
+				fmt.Fprintf(&b, "destination %q requires password, passwordFile, privateKeyFile or %s%s\n", name, envSFTPPasswordPrefix, envName(name))
+			}
+			continue
+		}
+		if dest.Provider == "gcs" {
+			if dest.Credentials == "" {
+				fmt.Fprintf(&b, "destination %q requires credentials, credentialsFile or %s%s\n", name, envGCSCredentialsPrefix, envName(name))
+			}
+			continue
+		}
+		if dest.Provider == "azureblob" {
+			if dest.AccountKey == "" && dest.SASToken == "" {
+				fmt.Fprintf(&b, "destination %q requires accountKey, accountKeyFile, sasToken, sasTokenFile, %s%s or %s%s\n", name, envAzureKeyPrefix, envName(name), envAzureSASPrefix, envName(name))
+			}
+			continue
+		}
+		if dest.Provider != "s3" && dest.Provider != "minio" {
+			continue // Other providers check their own credentials
+		}
+		if dest.AccessKeyID == "" {
+			fmt.Fprintf(&b, "destination %q requires accessKeyId, accessKeyIdFile or %s%s\n", name, envS3KeyPrefix, envName(name))
+		}
+		if dest.SecretAccessKey == "" {
+			fmt.Fprintf(&b, "destination %q requires secretAccessKey, secretAccessKeyFile or %s%s\n", name, envS3SecretPrefix, envName(name))
+		}
+	}
+
+	if b.Len() > 0 {
+		return fmt.Errorf("missing secrets:\n%s", b.String())
+	}
+	return nil
+}
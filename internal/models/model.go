@@ -1,14 +1,16 @@
 package models
 
+import "time"
+
 type Config struct {
 	Sources      SourcesConfig                `mapstructure:"sources"  validate:"required"`
-	Destinations map[string]DestinationConfig `mapstructure:"destinations"  validate:"required"`
-	Jobs         map[string]JobConfig         `mapstructure:"jobs"  validate:"required"`
+	Destinations map[string]DestinationConfig `mapstructure:"destinations"  validate:"required,dive"`
+	Jobs         map[string]JobConfig         `mapstructure:"jobs"  validate:"required,dive"`
 }
 
 type SourcesConfig struct {
-	Databases   map[string]DatabaseConfig  `mapstructure:"databases"   validate:"required_without=Directories"`
-	Directories map[string]DirectoryConfig `mapstructure:"directories" validate:"required_without=Databases"`
+	Databases   map[string]DatabaseConfig  `mapstructure:"databases"   validate:"required_without=Directories,dive"`
+	Directories map[string]DirectoryConfig `mapstructure:"directories" validate:"required_without=Databases,dive"`
 }
 
 type DatabaseConfig struct {
@@ -18,24 +20,121 @@ type DatabaseConfig struct {
 	User     string `mapstructure:"user"  validate:"required"`
 	Password string `mapstructure:"password"  validate:"required"`
 	Name     string `mapstructure:"name"  validate:"required"`
+
+	// PasswordFile is an alternative to Password; it is read when the config is loaded.
+	PasswordFile string `mapstructure:"passwordFile"`
 }
 
 type DirectoryConfig struct {
-	Path string `mapstructure:"path"  validate:"required,dir"`
+	// Path is not required to exist when the config is loaded, so that an
+	// unmounted share only fails the jobs backing it up.
+	Path string `mapstructure:"path"  validate:"required"`
+
+	// Include and Exclude are glob patterns matched against paths relative
+	// to Path, where "**" matches any number of directories. When Include is
+	// set, only files matching one of its patterns are backed up. Anything
+	// matching Exclude is left out, as is anything listed in a .backupignore
+	// file, which uses gitignore syntax.
+	Include []string `mapstructure:"include"`
+	Exclude []string `mapstructure:"exclude"`
+	// MaxFileSize leaves out files larger than this, e.g. "100MiB".
+	MaxFileSize string `mapstructure:"maxFileSize"`
+	// ExcludeIfPresent leaves out directories containing a file with one of
+	// these names, e.g. ".nobackup".
+	ExcludeIfPresent []string `mapstructure:"excludeIfPresent"`
 }
 
 type DestinationConfig struct {
-	Provider        string `mapstructure:"provider"  validate:"required,oneof=s3 minio"`
-	BucketName      string `mapstructure:"bucketName"  validate:"required"`
-	AccessKeyID     string `mapstructure:"accessKeyId"  validate:"required"`
-	SecretAccessKey string `mapstructure:"secretAccessKey"  validate:"required"`
-	Region          string `mapstructure:"region" validate:"required_if=Provider s3"`
-	EndpointURL     string `mapstructure:"endpointUrl" validate:"required_if=Provider minio,url"`
+	// Provider selects the storage backend. The settings each provider
+	// requires are checked by the provider when the config is loaded.
+	Provider        string `mapstructure:"provider"  validate:"required"`
+	BucketName      string `mapstructure:"bucketName"`
+	AccessKeyID     string `mapstructure:"accessKeyId"`
+	SecretAccessKey string `mapstructure:"secretAccessKey"`
+	Region          string `mapstructure:"region"`
+	EndpointURL     string `mapstructure:"endpointUrl" validate:"omitempty,url"`
+
+	// Path is the directory a local, SFTP or WebDAV destination stores
+	// archives in. For WebDAV it is relative to EndpointURL.
+	Path string `mapstructure:"path"`
+
+	// Host, Port and User locate an SFTP server. It authenticates with
+	// Password and/or PrivateKeyFile, and its host key must be listed in
+	// KnownHostsFile (default ~/.ssh/known_hosts). WebDAV destinations
+	// authenticate with User and Password.
+	Host           string `mapstructure:"host"`
+	Port           int    `mapstructure:"port"`
+	User           string `mapstructure:"user"`
+	Password       string `mapstructure:"password"`
+	PasswordFile   string `mapstructure:"passwordFile"`
+	PrivateKeyFile string `mapstructure:"privateKeyFile"`
+	KnownHostsFile string `mapstructure:"knownHostsFile"`
+
+	// AccountName and Container locate an Azure Blob Storage container. It
+	// authenticates with the account's shared key or with a SAS token, each
+	// of which can also be read from a file.
+	AccountName    string `mapstructure:"accountName"`
+	Container      string `mapstructure:"container"`
+	AccountKey     string `mapstructure:"accountKey"`
+	AccountKeyFile string `mapstructure:"accountKeyFile"`
+	SASToken       string `mapstructure:"sasToken"`
+	SASTokenFile   string `mapstructure:"sasTokenFile"`
+
+	// Credentials is the JSON key of the service account a GCS destination
+	// authenticates as. CredentialsFile is the path of that key instead.
+	Credentials     string `mapstructure:"credentials"`
+	CredentialsFile string `mapstructure:"credentialsFile"`
+
+	// AccessKeyIDFile and SecretAccessKeyFile are alternatives to AccessKeyID and
+	// SecretAccessKey; they are read when the config is loaded.
+	AccessKeyIDFile     string `mapstructure:"accessKeyIdFile"`
+	SecretAccessKeyFile string `mapstructure:"secretAccessKeyFile"`
+
+	// Retention applies to every job uploading to this destination and takes
+	// precedence over the job's own retention.
+	Retention *RetentionConfig `mapstructure:"retention"`
+	// Encryption applies to every job uploading to this destination and takes
+	// precedence over the job's own encryption.
+	Encryption *EncryptionConfig `mapstructure:"encryption"`
+	// Upload tunes multipart uploads to this destination.
+	Upload UploadConfig `mapstructure:"upload"`
+	// Prefix is a template prepended to the name of every archive stored on
+	// this destination, e.g. "{{.Host}}/{{.Job}}/". A job's own prefix takes
+	// precedence.
+	Prefix string `mapstructure:"prefix"`
+}
+
+// UploadConfig tunes multipart uploads. Zero values select the defaults.
+type UploadConfig struct {
+	// PartSizeMiB is the size of each part, at least 5 MiB (default 16).
+	PartSizeMiB int `mapstructure:"partSizeMiB"`
+	// Concurrency is the number of parts uploaded in parallel (default 4).
+	Concurrency int `mapstructure:"concurrency"`
+	// MaxRetries is how many times a failed part is retried (default 5).
+	MaxRetries int `mapstructure:"maxRetries"`
+	// AbortIncompleteAfter is the age after which incomplete multipart
+	// uploads left by crashed runs are aborted (default 24h).
+	AbortIncompleteAfter time.Duration `mapstructure:"abortIncompleteAfter"`
 }
 
 type OutputConfig struct {
-	Dir  string `mapstructure:"dir"  validate:"required,dir"`
+	// Dir is created when a job runs if it does not exist.
+	Dir  string `mapstructure:"dir"  validate:"required"`
 	Name string `mapstructure:"name"  validate:"required"`
+	// Streaming uploads the archive while it is being created instead of
+	// staging copies of the sources and the archive in Dir.
+	Streaming bool `mapstructure:"streaming"`
+	// Compression selects how the archive is compressed.
+	Compression CompressionConfig `mapstructure:"compression"`
+}
+
+// CompressionConfig selects the algorithm and level archives are compressed
+// with. Zero values select gzip at its default level.
+type CompressionConfig struct {
+	// Algorithm is one of "gzip", "zstd", "xz" or "none".
+	Algorithm string `mapstructure:"algorithm"`
+	// Level ranges from 1 (fastest) to 9 for gzip and xz, and to 22 for zstd.
+	Level int `mapstructure:"level"`
 }
 
 type JobConfig struct {
@@ -43,4 +142,52 @@ type JobConfig struct {
 	Databases    []string     `mapstructure:"databases" validate:"required_without=Directories"`
 	Directories  []string     `mapstructure:"directories" validate:"required_without=Databases"`
 	Destinations []string     `mapstructure:"destinations" validate:"required,min=1"`
+	// Schedule is only used by the daemon command; jobs without one are run on demand.
+	Schedule *ScheduleConfig `mapstructure:"schedule"`
+	// Retention is used by the prune command; jobs without one are never pruned.
+	Retention *RetentionConfig `mapstructure:"retention"`
+	// Encryption encrypts archives before they are uploaded.
+	Encryption *EncryptionConfig `mapstructure:"encryption"`
+	// Prefix overrides the prefix template of every destination of the job.
+	Prefix string `mapstructure:"prefix"`
+}
+
+type ScheduleConfig struct {
+	// Cron is a standard five-field cron expression or a descriptor such as "@daily".
+	Cron string `mapstructure:"cron"  validate:"required"`
+	// Timezone is an IANA time zone name; defaults to the local time zone.
+	Timezone string `mapstructure:"timezone"`
+	// Jitter delays each run by a random duration up to this value.
+	Jitter time.Duration `mapstructure:"jitter"  validate:"gte=0"`
+}
+
+// RetentionConfig decides which remote backups the prune command keeps.
+// A backup is kept when any of the Keep rules selects it, and MaxAge then
+// removes kept backups that are older than it. The most recent backup is
+// always kept.
+type RetentionConfig struct {
+	// KeepLast keeps the n most recent backups.
+	KeepLast int `mapstructure:"keepLast"  validate:"gte=0"`
+	// KeepDaily, KeepWeekly, KeepMonthly and KeepYearly keep the most recent
+	// backup of each of the last n days, weeks, months and years that have one.
+	KeepDaily   int `mapstructure:"keepDaily"  validate:"gte=0"`
+	KeepWeekly  int `mapstructure:"keepWeekly"  validate:"gte=0"`
+	KeepMonthly int `mapstructure:"keepMonthly"  validate:"gte=0"`
+	KeepYearly  int `mapstructure:"keepYearly"  validate:"gte=0"`
+	// MaxAge removes backups older than this, e.g. "2160h" for 90 days.
+	MaxAge time.Duration `mapstructure:"maxAge"  validate:"gte=0"`
+}
+
+// EncryptionConfig encrypts archives with age before upload, either to a set
+// of X25519 recipients or with a passphrase.
+type EncryptionConfig struct {
+	// Recipients are age X25519 public keys ("age1...").
+	Recipients []string `mapstructure:"recipients"`
+	// Passphrase is an alternative to Recipients.
+	Passphrase string `mapstructure:"passphrase"`
+	// PassphraseFile is an alternative to Passphrase; it is read when the config is loaded.
+	PassphraseFile string `mapstructure:"passphraseFile"`
+	// IdentityFile holds the age private keys used to decrypt archives
+	// encrypted to Recipients. Only needed to restore or verify.
+	IdentityFile string `mapstructure:"identityFile"`
 }
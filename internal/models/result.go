@@ -0,0 +1,109 @@
+package models
+
+import "time"
+
+// Status describes the outcome of a job, source or destination.
+type Status string
+
+const (
+	StatusSuccess Status = "success"
+	StatusPartial Status = "partial"
+	StatusFailed  Status = "failed"
+)
+
+// SourceResult is the outcome of backing up a single database or directory.
+type SourceResult struct {
+	Name     string        `json:"name"`
+	Kind     string        `json:"kind"` // "database" or "directory"
+	Status   Status        `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+	// Path is the absolute path of a directory source.
+	Path string `json:"path,omitempty"`
+	// ArchivePath is the directory of the archive the source is stored in.
+	ArchivePath string `json:"archivePath,omitempty"`
+}
+
+// DestinationResult is the outcome of uploading the archive to a single destination.
+type DestinationResult struct {
+	Name      string        `json:"name"`
+	ObjectKey string        `json:"objectKey,omitempty"`
+	Status    Status        `json:"status"`
+	Error     string        `json:"error,omitempty"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// JobResult is the outcome of a single backup job run.
+type JobResult struct {
+	Job          string              `json:"job"`
+	Status       Status              `json:"status"`
+	Error        string              `json:"error,omitempty"`
+	StartedAt    time.Time           `json:"startedAt"`
+	Duration     time.Duration       `json:"duration"`
+	ArchiveSize  int64               `json:"archiveSize"`
+	Sources      []SourceResult      `json:"sources"`
+	Destinations []DestinationResult `json:"destinations"`
+}
+
+// Fail marks the whole job as failed with the given error.
+func (r *JobResult) Fail(err error) {
+	r.Status = StatusFailed
+	r.Error = err.Error()
+}
+
+// Resolve derives the job status from its source and destination outcomes.
+// A job already marked as failed stays failed. Otherwise it fails when no
+// source was backed up or no destination received the archive, and is
+// partial when only some of them did.
+func (r *JobResult) Resolve() {
+	if r.Status == StatusFailed {
+		return
+	}
+
+	sources := countStatus(len(r.Sources), func(i int) Status { return r.Sources[i].Status })
+	destinations := countStatus(len(r.Destinations), func(i int) Status { return r.Destinations[i].Status })
+
+	switch {
+	case sources == StatusFailed || destinations == StatusFailed:
+		r.Status = StatusFailed
+		if r.Error == "" {
+			r.Error = "no source was backed up or no destination received the archive"
+		}
+	case sources == StatusPartial || destinations == StatusPartial:
+		r.Status = StatusPartial
+	default:
+		r.Status = StatusSuccess
+	}
+}
+
+// countStatus folds n individual outcomes into a single status.
+func countStatus(n int, status func(i int) Status) Status {
+	succeeded, failed := 0, 0
+	for i := 0; i < n; i++ {
+		switch status(i) {
+		case StatusSuccess:
+			succeeded++
+		case StatusFailed:
+			failed++
+		}
+	}
+	switch {
+	case succeeded == n:
+		return StatusSuccess
+	case failed == n:
+		return StatusFailed
+	default:
+		return StatusPartial
+	}
+}
+
+// RunResult collects the outcome of every job run by a single invocation.
+type RunResult struct {
+	Jobs []JobResult `json:"jobs"`
+}
+
+// Status reports success when every job succeeded, failed when every job
+// failed, and partial otherwise.
+func (r RunResult) Status() Status {
+	return countStatus(len(r.Jobs), func(i int) Status { return r.Jobs[i].Status })
+}
@@ -0,0 +1,151 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/tderick/backup-companion-go/internal/backup"
+	"github.com/tderick/backup-companion-go/internal/models"
+)
+
+// Scheduler runs backup jobs according to their cron schedules.
+type Scheduler struct {
+	cfg  *models.Config
+	cron *cron.Cron
+	jobs []*scheduledJob
+}
+
+// New registers every job that has a schedule. It fails if no job is
+// scheduled or if any schedule is invalid.
+func New(cfg *models.Config) (*Scheduler, error) {
+	logger := cronLogger{}
+	s := &Scheduler{
+		cfg: cfg,
+		cron: cron.New(
+			cron.WithLogger(logger),
+			cron.WithChain(cron.Recover(logger)),
+		),
+	}
+
+	jobNames := make([]string, 0, len(cfg.Jobs))
+	for jobName, job := range cfg.Jobs {
+		if job.Schedule != nil {
+			jobNames = append(jobNames, jobName)
+		}
+	}
+	sort.Strings(jobNames)
+
+	var validationErrors []string
+	for _, jobName := range jobNames {
+		schedule := cfg.Jobs[jobName].Schedule
+
+		spec, err := cronSpec(*schedule)
+		if err != nil {
+			validationErrors = append(validationErrors, fmt.Sprintf("job %q: %v", jobName, err))
+			continue
+		}
+		job := &scheduledJob{scheduler: s, name: jobName, jitter: schedule.Jitter}
+		if job.id, err = s.cron.AddJob(spec, job); err != nil {
+			validationErrors = append(validationErrors, fmt.Sprintf("job %q has an invalid cron expression %q: %v", jobName, schedule.Cron, err))
+			continue
+		}
+		s.jobs = append(s.jobs, job)
+	}
+
+	if len(validationErrors) > 0 {
+		return nil, errors.New(strings.Join(validationErrors, "; "))
+	}
+	if len(s.jobs) == 0 {
+		return nil, errors.New("no job in the config has a schedule")
+	}
+	return s, nil
+}
+
+// Run starts the scheduler and blocks until ctx is cancelled. It then stops
+// scheduling new runs and waits for running jobs to finish. Jobs run with
+// jobCtx, which lets the caller bound that wait by cancelling it separately.
+func (s *Scheduler) Run(ctx, jobCtx context.Context) {
+	for _, job := range s.jobs {
+		job.ctx, job.stop = jobCtx, ctx
+	}
+
+	s.cron.Start()
+	for _, job := range s.jobs {
+		slog.Info("Scheduled backup job", "job_name", job.name, "next_run", s.cron.Entry(job.id).Next)
+	}
+
+	<-ctx.Done()
+	slog.Info("Shutting down scheduler, waiting for running jobs to finish")
+	<-s.cron.Stop().Done()
+	slog.Info("Scheduler stopped")
+}
+
+// scheduledJob adapts a backup job to cron.Job.
+type scheduledJob struct {
+	scheduler *Scheduler
+	id        cron.EntryID
+	name      string
+	jitter    time.Duration
+	// ctx is passed to the backup, stop aborts a run still waiting on its jitter.
+	ctx, stop context.Context
+	// running guarantees that a job never overlaps with itself; different
+	// jobs may still run concurrently.
+	running sync.Mutex
+}
+
+func (j *scheduledJob) Run() {
+	if !j.running.TryLock() {
+		slog.Warn("Skipping scheduled backup job, previous run still in progress", "job_name", j.name)
+		return
+	}
+	defer j.running.Unlock()
+
+	if j.jitter > 0 {
+		delay := rand.N(j.jitter)
+		slog.Info("Delaying scheduled backup job", "job_name", j.name, "jitter", delay)
+		select {
+		case <-time.After(delay):
+		case <-j.stop.Done():
+			slog.Info("Scheduled backup job cancelled before it started", "job_name", j.name)
+			return
+		}
+	}
+
+	result := backup.Execute(j.ctx, j.scheduler.cfg, []string{j.name})
+	if result.Status() != models.StatusSuccess {
+		slog.Error("Scheduled backup job did not complete successfully", "job_name", j.name, "status", result.Status())
+	}
+}
+
+// cronSpec builds the cron spec for a schedule, applying its time zone.
+func cronSpec(schedule models.ScheduleConfig) (string, error) {
+	if schedule.Cron == "" {
+		return "", errors.New("schedule requires a cron expression")
+	}
+	if schedule.Timezone == "" {
+		return schedule.Cron, nil
+	}
+	if _, err := time.LoadLocation(schedule.Timezone); err != nil {
+		return "", fmt.Errorf("invalid schedule timezone %q: %w", schedule.Timezone, err)
+	}
+	return "CRON_TZ=" + schedule.Timezone + " " + schedule.Cron, nil
+}
+
+// cronLogger forwards the cron library's logs to slog.
+type cronLogger struct{}
+
+func (cronLogger) Info(msg string, keysAndValues ...interface{}) {
+	slog.Debug("cron: "+msg, keysAndValues...)
+}
+
+func (cronLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	slog.Error("cron: "+msg, append(keysAndValues, "error", err)...)
+}
@@ -0,0 +1,20 @@
+// Package version reports the version of the running binary.
+package version
+
+import "runtime/debug"
+
+// Version is set when building a release, e.g. with
+// -ldflags "-X github.com/tderick/backup-companion-go/internal/version.Version=v1.2.0".
+var Version string
+
+// String returns the version of the binary: Version when set, otherwise the
+// module version recorded by the Go toolchain, or "dev" for local builds.
+func String() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}